@@ -27,17 +27,346 @@ type CfsslIssuerSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// URL is an url of a Cfssl Server
-	URL string `json:"url"`
+	// Mode selects the signing backend, either remote (the default), sending
+	// signing requests to the Cfssl Servers at URL and URLs, or local,
+	// signing in the controller with the CA configured by Local.
+	// +optional
+	Mode SigningMode `json:"mode,omitempty"`
+
+	// Local configures the CA and signing profiles of the local mode.
+	// +optional
+	Local *LocalSigner `json:"local,omitempty"`
+
+	// URL is an url of a Cfssl Server. Either URL or URLs must be set in
+	// remote mode.
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// URLs is a list of further Cfssl Server urls. Together with URL they
+	// form the endpoints signing requests are sent to.
+	// +optional
+	URLs []string `json:"urls,omitempty"`
+
+	// Strategy selects the order in which endpoints are tried, either
+	// OrderedFailover (the default) or RoundRobin.
+	// +optional
+	Strategy EndpointStrategy `json:"strategy,omitempty"`
+
+	// FailureThreshold is the number of consecutive failures after which an
+	// endpoint is skipped for the Cooldown period. Defaults to 3.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+
+	// Cooldown is the period an endpoint is skipped for after reaching the
+	// FailureThreshold. Defaults to 30s.
+	// +optional
+	Cooldown *metav1.Duration `json:"cooldown,omitempty"`
+
+	// SignTimeout bounds the time a signing request to cfssl may take,
+	// including failover to other endpoints, each of which is given an even
+	// share of the time left. Requests exceeding it are aborted and retried.
+	// Defaults to 30s.
+	// +optional
+	SignTimeout *metav1.Duration `json:"signTimeout,omitempty"`
 
 	// CABundle is a base64 encoded TLS certificate used to verify connections
-	// to the step certificates server. If not set the system root certificates
-	// are used to validate the TLS connection.
-	CABundle []byte `json:"caBundle"`
+	// to the Cfssl Server, in addition to the system root certificates. Unless
+	// CAChain is set it is also used as the chain of the issuing CA. Either
+	// CABundle or CABundleRef must be set in remote mode.
+	// +optional
+	CABundle []byte `json:"caBundle,omitempty"`
+
+	// CABundleRef references a Secret or ConfigMap key holding the CA bundle,
+	// as an alternative to CABundle. It is resolved from the same namespace
+	// as AuthKeySecretRef and reloaded whenever the referenced object changes.
+	// +optional
+	CABundleRef *CABundleReference `json:"caBundleRef,omitempty"`
+
+	// CAChain is a base64 encoded PEM chain of the CA signing certificates,
+	// ordered from the issuing CA to the root. The intermediates are appended
+	// to issued certificates and the root is returned as their CA. Set it if
+	// the Cfssl Server's TLS certificate is not issued by the signing CA.
+	// +optional
+	CAChain []byte `json:"caChain,omitempty"`
+
+	// DiscoverCAChain fetches the signing certificate from the Cfssl
+	// Server's info endpoint, using the configured profile, and uses it as
+	// CA chain instead of CAChain or CABundle. If the signing certificate is
+	// an intermediate CA, the chain is completed up to the root with the
+	// certificates of CABundle. Without them, the signing certificate is
+	// used as root.
+	// +optional
+	DiscoverCAChain bool `json:"discoverCAChain,omitempty"`
+
+	// CAChainRefreshInterval is how often a discovered CA chain is fetched
+	// again. Defaults to 1h.
+	// +optional
+	CAChainRefreshInterval *metav1.Duration `json:"caChainRefreshInterval,omitempty"`
 
 	// Profile is signing profile used by the Cfssl Server. If omitted, the
 	// default profile will be used
 	Profile string `json:"profile,omitempty"`
+
+	// Label selects the signing key of a multirootca server serving several
+	// CAs. It is sent with signing and info requests, so the issuer only
+	// becomes ready if the server has a signing key with this label. If
+	// omitted, the default signing key of the server is used.
+	// +optional
+	Label string `json:"label,omitempty"`
+
+	// ProfileRules select the signing profile of CertificateRequests. The
+	// profile of the first matching rule is used, falling back to Profile
+	// if no rule matches.
+	// +optional
+	ProfileRules []ProfileRule `json:"profileRules,omitempty"`
+
+	// AllowedProfileOverrides are the profiles CertificateRequests may select
+	// with the certmanager.thg.io/profile annotation, taking precedence over
+	// ProfileRules. Requests selecting any other profile are denied. If
+	// empty, the annotation is not allowed.
+	// +optional
+	AllowedProfileOverrides []string `json:"allowedProfileOverrides,omitempty"`
+
+	// MaxDuration caps the validity requested by CertificateRequests. Longer
+	// durations are reduced to MaxDuration. Requests without a duration are
+	// signed with the expiry of the signing profile. Requested durations are
+	// only honored in local mode, as the sign API of cfssl does not accept
+	// them; a Cfssl Server signs with the expiry of the profile.
+	// +optional
+	MaxDuration *metav1.Duration `json:"maxDuration,omitempty"`
+
+	// Verification controls the verification of signed certificates against
+	// their CertificateRequest: the public key, SANs and requested key usages
+	// of the certificate, and its chain to the CA handed to cert-manager,
+	// i.e. the CA chain of the issuer, which falls back to CABundle. Either
+	// strict (the default), failing CertificateRequests whose certificate
+	// fails verification, warn, recording a Warning event instead, or off.
+	// +optional
+	Verification VerificationMode `json:"verification,omitempty"`
+
+	// AuthKeySecretRef references a Secret key holding the hex encoded key
+	// used to authenticate signing requests to the Cfssl Server. If set,
+	// requests are sent to the authsign endpoint. CfsslIssuers read the
+	// Secret from their own namespace, CfsslClusterIssuers from the cluster
+	// resource namespace of the controller.
+	// +optional
+	AuthKeySecretRef *SecretKeySelector `json:"authKeySecretRef,omitempty"`
+
+	// ClientCertSecretRef references a kubernetes.io/tls Secret whose
+	// certificate and key are presented to the Cfssl Server as a TLS client
+	// certificate. It is resolved from the same namespace as AuthKeySecretRef.
+	// +optional
+	ClientCertSecretRef *LocalObjectReference `json:"clientCertSecretRef,omitempty"`
+
+	// RetryPolicy configures how CertificateRequests are retried after
+	// transient signing failures.
+	// +optional
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+
+	// CircuitBreaker configures when signing requests stop being sent to the
+	// Cfssl Server after repeated transient failures.
+	// +optional
+	CircuitBreaker *CircuitBreakerPolicy `json:"circuitBreaker,omitempty"`
+
+	// RateLimit limits the signing requests sent to the Cfssl Server.
+	// +optional
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+}
+
+// RateLimit limits the rate and concurrency of signing requests of an
+// issuer. Requests over the limits are requeued instead of being sent.
+type RateLimit struct {
+	// RequestsPerSecond is the sustained rate of signing requests.
+	// Unlimited if unset.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RequestsPerSecond int32 `json:"requestsPerSecond,omitempty"`
+
+	// Burst is the number of requests that can be sent at once above
+	// RequestsPerSecond. Defaults to RequestsPerSecond.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+
+	// MaxInFlight is the number of concurrent signing requests.
+	// Unlimited if unset.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxInFlight int32 `json:"maxInFlight,omitempty"`
+}
+
+// CircuitBreakerPolicy configures the circuit breaker of an issuer. The
+// breaker opens after FailureThreshold consecutive transient failures and
+// lets HalfOpenRequests trial requests through after OpenDuration.
+type CircuitBreakerPolicy struct {
+	// FailureThreshold is the number of consecutive transient failures
+	// opening the breaker. Defaults to 5.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+
+	// OpenDuration is how long the breaker stays open before letting trial
+	// requests through. Defaults to 1m.
+	// +optional
+	OpenDuration *metav1.Duration `json:"openDuration,omitempty"`
+
+	// HalfOpenRequests is the number of trial requests let through while
+	// half-open. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	HalfOpenRequests int32 `json:"halfOpenRequests,omitempty"`
+}
+
+// RetryPolicy configures the retries of failed signing requests. Retries
+// back off exponentially from InitialBackoff to MaxBackoff until MaxAttempts
+// or the Deadline is reached, after which the CertificateRequest fails.
+type RetryPolicy struct {
+	// InitialBackoff is the delay before the first retry. Defaults to 5s.
+	// +optional
+	InitialBackoff *metav1.Duration `json:"initialBackoff,omitempty"`
+
+	// MaxBackoff is the longest delay between retries. Defaults to 5m.
+	// +optional
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
+
+	// MaxAttempts is the number of signing attempts before giving up.
+	// Unlimited if unset.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxAttempts int32 `json:"maxAttempts,omitempty"`
+
+	// Deadline is the time after the creation of a CertificateRequest after
+	// which it is no longer retried. Unlimited if unset.
+	// +optional
+	Deadline *metav1.Duration `json:"deadline,omitempty"`
+}
+
+// CABundleReference references a key of a Secret or ConfigMap.
+type CABundleReference struct {
+	// Kind of the referenced object, either Secret or ConfigMap.
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	Kind string `json:"kind"`
+
+	// Name of the referenced object.
+	Name string `json:"name"`
+
+	// Key of the entry holding the PEM encoded CA bundle.
+	Key string `json:"key"`
+}
+
+// LocalObjectReference references an object in the same namespace.
+type LocalObjectReference struct {
+	// Name of the referenced object.
+	Name string `json:"name"`
+}
+
+// SigningMode selects the signing backend of an issuer.
+// +kubebuilder:validation:Enum=remote;local
+type SigningMode string
+
+const (
+	// RemoteMode sends signing requests to Cfssl Servers.
+	RemoteMode SigningMode = "remote"
+
+	// LocalMode signs certificates in the controller with cfssl's local
+	// signer, without a Cfssl Server.
+	LocalMode SigningMode = "local"
+)
+
+// VerificationMode controls the verification of signed certificates.
+// +kubebuilder:validation:Enum=strict;warn;off
+type VerificationMode string
+
+const (
+	// StrictVerification fails CertificateRequests whose certificate fails
+	// verification.
+	StrictVerification VerificationMode = "strict"
+
+	// WarnVerification records a Warning event on CertificateRequests whose
+	// certificate fails verification, issuing the certificate regardless.
+	WarnVerification VerificationMode = "warn"
+
+	// NoVerification does not verify signed certificates.
+	NoVerification VerificationMode = "off"
+)
+
+// LocalSigner configures the local signing mode.
+type LocalSigner struct {
+	// CASecretRef references a kubernetes.io/tls Secret holding the
+	// certificate and key of the signing CA. It is resolved from the same
+	// namespace as AuthKeySecretRef. Unless CAChain is set, the certificate
+	// is also used as the chain of the issuing CA.
+	CASecretRef LocalObjectReference `json:"caSecretRef"`
+
+	// Config is a cfssl JSON configuration whose signing section defines the
+	// signing profiles, selected by Profile. If omitted, certificates are
+	// signed with cfssl's default profile, valid for one year.
+	// +optional
+	Config string `json:"config,omitempty"`
+}
+
+// ProfileRule selects the signing profile of the CertificateRequests
+// meeting all of its conditions. A rule without conditions matches every
+// request.
+type ProfileRule struct {
+	// Profile is the signing profile of the matched CertificateRequests.
+	// +kubebuilder:validation:MinLength=1
+	Profile string `json:"profile"`
+
+	// Usages matches requests asking for all of these key usages, as named
+	// by cert-manager.
+	// +optional
+	Usages []string `json:"usages,omitempty"`
+
+	// IsCA matches requests for CA certificates if true, and requests for
+	// other certificates if false.
+	// +optional
+	IsCA *bool `json:"isCA,omitempty"`
+
+	// DNSNames matches requests with DNS SANs, all of which match one of
+	// these patterns. A `*` label matches any single label, e.g.
+	// `*.example.com`.
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+
+	// URIs matches requests with URI SANs, all of which match one of these
+	// patterns. A `*` matches any sequence of characters other than `/`,
+	// e.g. `spiffe://cluster.local/ns/*/sa/*`.
+	// +optional
+	URIs []string `json:"uris,omitempty"`
+
+	// MinDuration matches requests for certificates valid for at least this
+	// duration.
+	// +optional
+	MinDuration *metav1.Duration `json:"minDuration,omitempty"`
+
+	// MaxDuration matches requests for certificates valid for at most this
+	// duration.
+	// +optional
+	MaxDuration *metav1.Duration `json:"maxDuration,omitempty"`
+}
+
+// EndpointStrategy selects the order in which Cfssl Server endpoints are tried.
+// +kubebuilder:validation:Enum=OrderedFailover;RoundRobin
+type EndpointStrategy string
+
+const (
+	// OrderedFailover always tries endpoints in the configured order.
+	OrderedFailover EndpointStrategy = "OrderedFailover"
+
+	// RoundRobin starts each request at the endpoint following the one
+	// used for the previous request.
+	RoundRobin EndpointStrategy = "RoundRobin"
+)
+
+// SecretKeySelector references a key of a Secret.
+type SecretKeySelector struct {
+	// Name of the Secret.
+	Name string `json:"name"`
+
+	// Key of the entry in the Secret's data field.
+	Key string `json:"key"`
 }
 
 // CfsslIssuerStatus defines the observed state of CfsslIssuer
@@ -47,6 +376,49 @@ type CfsslIssuerStatus struct {
 
 	// +optional
 	Conditions []CfsslIssuerCondition `json:"conditions,omitempty"`
+
+	// Endpoints reports the health of the Cfssl Server endpoints as seen
+	// by the controller.
+	// +optional
+	Endpoints []EndpointStatus `json:"endpoints,omitempty"`
+
+	// CA describes the issuing CA certificates are signed with.
+	// +optional
+	CA *CAStatus `json:"ca,omitempty"`
+}
+
+// CAStatus describes an issuing CA certificate.
+type CAStatus struct {
+	// Subject of the CA certificate.
+	Subject string `json:"subject"`
+
+	// SerialNumber of the CA certificate.
+	SerialNumber string `json:"serialNumber"`
+
+	// NotAfter is the expiry time of the CA certificate.
+	NotAfter metav1.Time `json:"notAfter"`
+}
+
+// EndpointStatus describes the health of a single Cfssl Server endpoint.
+type EndpointStatus struct {
+	// URL of the endpoint.
+	URL string `json:"url"`
+
+	// Healthy is false while the endpoint is skipped after repeated failures.
+	Healthy bool `json:"healthy"`
+
+	// ConsecutiveFailures is the number of requests that failed since the
+	// last successful one.
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// LastError is the error returned by the last failed request.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// LastFailureTime is the time of the last failed request.
+	// +optional
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
 }
 
 // +kubebuilder:object:root=true
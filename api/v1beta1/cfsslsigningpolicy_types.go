@@ -0,0 +1,119 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KeyAlgorithm is the public key algorithm of a certificate request.
+// +kubebuilder:validation:Enum=RSA;ECDSA;Ed25519
+type KeyAlgorithm string
+
+const (
+	RSAKeyAlgorithm     KeyAlgorithm = "RSA"
+	ECDSAKeyAlgorithm   KeyAlgorithm = "ECDSA"
+	Ed25519KeyAlgorithm KeyAlgorithm = "Ed25519"
+)
+
+// PolicyIssuerReference selects the CfsslIssuer or CfsslClusterIssuer a
+// CfsslSigningPolicy applies to.
+type PolicyIssuerReference struct {
+	// Kind of the issuer.
+	// +kubebuilder:validation:Enum=CfsslIssuer;CfsslClusterIssuer
+	Kind string `json:"kind"`
+
+	// Name of the issuer. CfsslIssuers are matched in the namespace of the
+	// CertificateRequest.
+	Name string `json:"name"`
+}
+
+// CfsslSigningPolicySpec defines which CertificateRequests are approved.
+// Unset constraints allow any value.
+type CfsslSigningPolicySpec struct {
+	// IssuerRefs are the issuers whose CertificateRequests the policy applies to.
+	// +kubebuilder:validation:MinItems=1
+	IssuerRefs []PolicyIssuerReference `json:"issuerRefs"`
+
+	// Namespaces restricts the policy to CertificateRequests created in
+	// these namespaces. The policy applies to all namespaces if empty.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// DNSNames are the allowed DNS SANs. A `*` label matches any single label,
+	// e.g. `*.example.com`. The common name must match them as well, as many
+	// clients still accept it as host name.
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+
+	// IPRanges are the CIDR ranges IP SANs must be in.
+	// +optional
+	IPRanges []string `json:"ipRanges,omitempty"`
+
+	// URIs are the allowed URI SANs. A `*` matches any sequence of characters
+	// other than `/`, e.g. `spiffe://cluster.local/ns/*/sa/*`.
+	// +optional
+	URIs []string `json:"uris,omitempty"`
+
+	// EmailAddresses are the allowed email SANs. A `*` matches any sequence
+	// of characters other than `/`, e.g. `*@example.com`.
+	// +optional
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+
+	// MaxDuration is the longest certificate duration that can be requested.
+	// +optional
+	MaxDuration *metav1.Duration `json:"maxDuration,omitempty"`
+
+	// KeyAlgorithms are the allowed public key algorithms.
+	// +optional
+	KeyAlgorithms []KeyAlgorithm `json:"keyAlgorithms,omitempty"`
+
+	// MinKeySize is the minimum size of the public key in bits.
+	// +optional
+	MinKeySize int `json:"minKeySize,omitempty"`
+
+	// Usages are the allowed key usages, as named by cert-manager.
+	// +optional
+	Usages []string `json:"usages,omitempty"`
+
+	// AllowCA allows requesting CA certificates.
+	// +optional
+	AllowCA bool `json:"allowCA,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// CfsslSigningPolicy is the Schema for the cfsslsigningpolicies API
+type CfsslSigningPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec CfsslSigningPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// CfsslSigningPolicyList contains a list of CfsslSigningPolicy
+type CfsslSigningPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CfsslSigningPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CfsslSigningPolicy{}, &CfsslSigningPolicyList{})
+}
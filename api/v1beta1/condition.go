@@ -5,12 +5,16 @@ import (
 )
 
 // ConditionType represents a CfsslIssuer condition type.
-// +kubebuilder:validation:Enum=Ready
+// +kubebuilder:validation:Enum=Ready;CircuitOpen
 type ConditionType string
 
 const (
 	// ConditionReady indicates that a CfsslIssuer is ready for use.
 	ConditionReady ConditionType = "Ready"
+
+	// ConditionCircuitOpen indicates that the circuit breaker of a
+	// CfsslIssuer stopped sending signing requests to the Cfssl Server.
+	ConditionCircuitOpen ConditionType = "CircuitOpen"
 )
 
 // ConditionStatus represents a condition's status.
@@ -35,7 +39,7 @@ const (
 
 // CfsslIssuerCondition contains condition information for the cfssl issuer.
 type CfsslIssuerCondition struct {
-	// Type of the condition, currently ('Ready').
+	// Type of the condition, one of ('Ready', 'CircuitOpen').
 	Type ConditionType `json:"type"`
 
 	// Status of the condition, one of ('True', 'False', 'Unknown').
@@ -0,0 +1,200 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var issued = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newCertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0xbeef),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "www.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+		NotBefore:    issued,
+		NotAfter:     issued.Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func newRecord(t *testing.T) *Record {
+	cr := &cmapi.CertificateRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1234"},
+		Spec: cmapi.CertificateRequestSpec{
+			IssuerRef: cmmeta.ObjectReference{Group: "certmanager.thg.io", Kind: "CfsslIssuer", Name: "cfssl"},
+			Username:  "alice",
+			Groups:    []string{"system:authenticated"},
+		},
+	}
+	r, err := NewRecord(cr, newCertificate(t), "https://cfssl.local", issued)
+	if err != nil {
+		t.Fatalf("failed to create record: %v", err)
+	}
+	return r
+}
+
+func TestNewRecord(t *testing.T) {
+	r := newRecord(t)
+
+	assert.Equal(t, ObjectReference{Namespace: "default", Name: "web", UID: "1234"}, r.CertificateRequest)
+	assert.Equal(t, IssuerReference{Group: "certmanager.thg.io", Kind: "CfsslIssuer", Name: "cfssl"}, r.IssuerRef)
+	assert.Equal(t, "alice", r.Username)
+	assert.Equal(t, []string{"system:authenticated"}, r.Groups)
+	assert.Equal(t, "beef", r.SerialNumber)
+	assert.Equal(t, "CN=example.com", r.Subject)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, r.DNSNames)
+	assert.Equal(t, []string{"10.0.0.1"}, r.IPAddresses)
+	assert.Equal(t, issued, r.NotBefore)
+	assert.Equal(t, issued.Add(24*time.Hour), r.NotAfter)
+
+	_, err := NewRecord(&cmapi.CertificateRequest{}, []byte("not-a-cert"), "", issued)
+	assert.Error(t, err)
+}
+
+func TestLogSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewLogSink(&buf)
+
+	r := newRecord(t)
+	assert.NoError(t, sink.Write(context.Background(), r))
+	assert.NoError(t, sink.Write(context.Background(), r))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if assert.Len(t, lines, 2) {
+		var decoded Record
+		assert.NoError(t, json.Unmarshal(lines[0], &decoded))
+		assert.Equal(t, r.SerialNumber, decoded.SerialNumber)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	tests := []struct {
+		desc     string
+		statuses []int
+		attempts int32
+		wantErr  bool
+	}{
+		{
+			desc:     "delivered",
+			statuses: []int{http.StatusOK},
+			attempts: 1,
+		},
+		{
+			desc:     "retried",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent},
+			attempts: 3,
+		},
+		{
+			desc:     "gives up",
+			statuses: []int{http.StatusInternalServerError},
+			attempts: 3,
+			wantErr:  true,
+		},
+		{
+			desc:     "rejected",
+			statuses: []int{http.StatusBadRequest},
+			attempts: 1,
+			wantErr:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				status := tc.statuses[len(tc.statuses)-1]
+				if int(n) <= len(tc.statuses) {
+					status = tc.statuses[n-1]
+				}
+				var record Record
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&record))
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			sink := NewWebhookSink(srv.URL, WithAttempts(3), WithBackoff(time.Millisecond))
+			err := sink.Write(context.Background(), newRecord(t))
+			assert.Equal(t, tc.wantErr, err != nil, "unexpected error: %v", err)
+			assert.Equal(t, tc.attempts, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+// blockingSink blocks writes until unblocked.
+type blockingSink struct {
+	unblock chan struct{}
+	written chan *Record
+}
+
+func (s *blockingSink) Name() string { return "blocking" }
+
+func (s *blockingSink) Write(_ context.Context, r *Record) error {
+	<-s.unblock
+	s.written <- r
+	return nil
+}
+
+func TestAuditorDoesNotBlock(t *testing.T) {
+	sink := &blockingSink{unblock: make(chan struct{}), written: make(chan *Record, 10)}
+	auditor := NewAuditor(logr.Discard(), 1, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = auditor.Start(ctx)
+		close(done)
+	}()
+
+	r := newRecord(t)
+	finished := make(chan struct{})
+	go func() {
+		// The first record is being written, the second queued and the
+		// third dropped
+		for i := 0; i < 3; i++ {
+			auditor.Record(r)
+		}
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Record blocked on an unavailable sink")
+	}
+
+	close(sink.unblock)
+	cancel()
+	<-done
+	assert.LessOrEqual(t, len(sink.written), 2)
+	assert.GreaterOrEqual(t, len(sink.written), 1)
+}
@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultQueueSize is the number of records buffered per sink.
+	DefaultQueueSize = 1000
+
+	// drainTimeout bounds the delivery of records still queued on shutdown.
+	drainTimeout = 5 * time.Second
+)
+
+var (
+	droppedRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "audit records dropped because the queue of the sink was full",
+		Namespace: "cfssl_issuer",
+		Name:      "audit_records_dropped_total",
+	}, []string{"sink"})
+	sinkErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "audit records sinks failed to deliver",
+		Namespace: "cfssl_issuer",
+		Name:      "audit_sink_errors_total",
+	}, []string{"sink"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(droppedRecords)
+	metrics.Registry.MustRegister(sinkErrors)
+}
+
+// Auditor delivers records to its sinks in the background, so that
+// issuance is never blocked by an unavailable sink. Each sink has its own
+// queue; records are dropped and counted once it is full.
+type Auditor struct {
+	log    logr.Logger
+	queues []*queue
+}
+
+type queue struct {
+	sink    Sink
+	records chan *Record
+}
+
+// NewAuditor returns an auditor delivering to sinks, buffering up to
+// queueSize records per sink.
+func NewAuditor(log logr.Logger, queueSize int, sinks ...Sink) *Auditor {
+	a := &Auditor{log: log}
+	for _, sink := range sinks {
+		a.queues = append(a.queues, &queue{
+			sink:    sink,
+			records: make(chan *Record, queueSize),
+		})
+	}
+	return a
+}
+
+// Record queues r for delivery to all sinks without blocking.
+func (a *Auditor) Record(r *Record) {
+	if a == nil {
+		return
+	}
+
+	for _, q := range a.queues {
+		select {
+		case q.records <- r:
+		default:
+			droppedRecords.WithLabelValues(q.sink.Name()).Inc()
+			a.log.Error(nil, "audit queue is full, dropping record", "sink", q.sink.Name(),
+				"certificaterequest", r.CertificateRequest, "serialNumber", r.SerialNumber)
+		}
+	}
+}
+
+// Start delivers queued records until ctx is done, then delivers the
+// records left in the queues. It implements manager.Runnable.
+func (a *Auditor) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, q := range a.queues {
+		wg.Add(1)
+		go func(q *queue) {
+			defer wg.Done()
+			a.run(ctx, q)
+		}(q)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (a *Auditor) run(ctx context.Context, q *queue) {
+	for {
+		select {
+		case r := <-q.records:
+			a.write(ctx, q.sink, r)
+		case <-ctx.Done():
+			a.drain(q)
+			return
+		}
+	}
+}
+
+func (a *Auditor) drain(q *queue) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case r := <-q.records:
+			a.write(ctx, q.sink, r)
+		default:
+			return
+		}
+	}
+}
+
+func (a *Auditor) write(ctx context.Context, sink Sink, r *Record) {
+	if err := sink.Write(ctx, r); err != nil {
+		sinkErrors.WithLabelValues(sink.Name()).Inc()
+		a.log.Error(err, "failed to write audit record", "sink", sink.Name(),
+			"certificaterequest", r.CertificateRequest, "serialNumber", r.SerialNumber)
+	}
+}
@@ -0,0 +1,94 @@
+// Package audit records the certificates issued by the controller and
+// delivers the records to sinks, e.g. a JSON-lines log or a webhook.
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Record describes an issued certificate and the CertificateRequest it was
+// issued for.
+type Record struct {
+	// Time is when the certificate was issued by the controller.
+	Time time.Time `json:"time"`
+
+	CertificateRequest ObjectReference `json:"certificateRequest"`
+	IssuerRef          IssuerReference `json:"issuerRef"`
+
+	// Username and Groups identify the user who created the
+	// CertificateRequest.
+	Username string   `json:"username,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+
+	// SerialNumber is the hex encoded serial number of the certificate.
+	SerialNumber   string    `json:"serialNumber"`
+	Subject        string    `json:"subject"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	EmailAddresses []string  `json:"emailAddresses,omitempty"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+
+	// Endpoint is the url of the cfssl server which signed the certificate.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// ObjectReference identifies a namespaced object.
+type ObjectReference struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid"`
+}
+
+// IssuerReference identifies the issuer a certificate was requested from.
+type IssuerReference struct {
+	Group string `json:"group,omitempty"`
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+}
+
+// NewRecord returns the record of the PEM encoded certificate issued for
+// cr. Only the first certificate of a chain is recorded.
+func NewRecord(cr *cmapi.CertificateRequest, certificate []byte, endpoint string, now time.Time) (*Record, error) {
+	cert, err := pki.DecodeX509CertificateBytes(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode issued certificate: %s", err)
+	}
+
+	r := &Record{
+		Time: now.UTC(),
+		CertificateRequest: ObjectReference{
+			Namespace: cr.Namespace,
+			Name:      cr.Name,
+			UID:       cr.UID,
+		},
+		IssuerRef: IssuerReference{
+			Group: cr.Spec.IssuerRef.Group,
+			Kind:  cr.Spec.IssuerRef.Kind,
+			Name:  cr.Spec.IssuerRef.Name,
+		},
+		Username:       cr.Spec.Username,
+		Groups:         cr.Spec.Groups,
+		SerialNumber:   cert.SerialNumber.Text(16),
+		Subject:        cert.Subject.String(),
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		NotBefore:      cert.NotBefore.UTC(),
+		NotAfter:       cert.NotAfter.UTC(),
+		Endpoint:       endpoint,
+	}
+	for _, ip := range cert.IPAddresses {
+		r.IPAddresses = append(r.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		r.URIs = append(r.URIs, uri.String())
+	}
+
+	return r, nil
+}
@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookAttempts = 5
+	defaultWebhookBackoff  = time.Second
+	maxWebhookBackoff      = time.Minute
+	webhookTimeout         = 10 * time.Second
+)
+
+// Sink delivers audit records.
+type Sink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	// Write delivers the record, returning an error if it was lost.
+	Write(ctx context.Context, r *Record) error
+}
+
+// LogSink writes records as JSON lines.
+type LogSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLogSink returns a sink writing one JSON object per record to w.
+func NewLogSink(w io.Writer) *LogSink {
+	return &LogSink{w: w}
+}
+
+func (s *LogSink) Name() string {
+	return "log"
+}
+
+func (s *LogSink) Write(_ context.Context, r *Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %s", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// WebhookSink posts records as JSON to a url. Failed deliveries are retried
+// with an exponential backoff.
+type WebhookSink struct {
+	url      string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+}
+
+// WebhookOption configures optional settings of a WebhookSink.
+type WebhookOption func(*WebhookSink)
+
+// WithAttempts configures the number of delivery attempts of a record.
+// Defaults to 5.
+func WithAttempts(attempts int) WebhookOption {
+	return func(s *WebhookSink) {
+		if attempts > 0 {
+			s.attempts = attempts
+		}
+	}
+}
+
+// WithBackoff configures the delay before the first retry, doubled on every
+// further retry. Defaults to 1s.
+func WithBackoff(backoff time.Duration) WebhookOption {
+	return func(s *WebhookSink) {
+		s.backoff = backoff
+	}
+}
+
+// WithHTTPClient configures the client records are posted with.
+func WithHTTPClient(client *http.Client) WebhookOption {
+	return func(s *WebhookSink) {
+		s.client = client
+	}
+}
+
+// NewWebhookSink returns a sink posting records to url.
+func NewWebhookSink(url string, opts ...WebhookOption) *WebhookSink {
+	s := &WebhookSink{
+		url:      url,
+		client:   &http.Client{Timeout: webhookTimeout},
+		attempts: defaultWebhookAttempts,
+		backoff:  defaultWebhookBackoff,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Write(ctx context.Context, r *Record) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %s", err)
+	}
+
+	delay := s.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.attempts {
+			return fmt.Errorf("failed to deliver audit record after %d attempts: %w", attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to deliver audit record: %w", err)
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxWebhookBackoff {
+			delay = maxWebhookBackoff
+		}
+	}
+}
+
+// post sends the record once, returning whether a failure may be retried.
+func (s *WebhookSink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook responded with %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/OpenSource-THG/cfssl-issuer/policy"
+	cmutil "github.com/cert-manager/cert-manager/pkg/api/util"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmetav1 "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/go-logr/logr"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const approverReason = "CfsslSigningPolicy"
+
+// CertificateRequestApproverReconciler approves or denies CertificateRequests
+// for cfssl issuers according to the CfsslSigningPolicies bound to them.
+// Requests no policy applies to are left for other approvers.
+type CertificateRequestApproverReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=certmanager.thg.io,resources=cfsslsigningpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cert-manager.io,resources=signers,verbs=approve,resourceNames=cfsslissuers.certmanager.thg.io/*;cfsslclusterissuers.certmanager.thg.io/*
+
+func (r *CertificateRequestApproverReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("certificaterequest", req.NamespacedName)
+
+	cr := &cmapi.CertificateRequest{}
+	if err := r.Client.Get(ctx, req.NamespacedName, cr); err != nil {
+		log.Error(err, "failed to retrieve CertificateRequest resource")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !pendingApproval(cr) {
+		return ctrl.Result{}, nil
+	}
+
+	policies := &cfsslv1beta1.CfsslSigningPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		log.Error(err, "failed to list CfsslSigningPolicies")
+		return ctrl.Result{}, err
+	}
+
+	var violations []string
+	for _, p := range policies.Items {
+		if !policy.Matches(p.Spec, cr) {
+			continue
+		}
+
+		err := policy.Evaluate(p.Spec, cr)
+		if err == nil {
+			return ctrl.Result{}, r.setApproval(ctx, cr, cmapi.CertificateRequestConditionApproved,
+				"Approved by CfsslSigningPolicy %s", p.Name)
+		}
+		violations = append(violations, fmt.Sprintf("%s: %v", p.Name, err))
+	}
+
+	if len(violations) == 0 {
+		log.V(4).Info("no CfsslSigningPolicy applies to the CertificateRequest. Ignoring.")
+		return ctrl.Result{}, nil
+	}
+
+	return ctrl.Result{}, r.setApproval(ctx, cr, cmapi.CertificateRequestConditionDenied,
+		"Denied by CfsslSigningPolicy %s", strings.Join(violations, "; "))
+}
+
+// SetupWithManager registers CertificateRequestApproverReconciler with the
+// given manager
+func (r *CertificateRequestApproverReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("certificaterequest-approver").
+		For(&cmapi.CertificateRequest{}).
+		Watches(&source.Kind{Type: &cfsslv1beta1.CfsslSigningPolicy{}}, handler.EnqueueRequestsFromMapFunc(r.pendingRequests)).
+		Complete(r)
+}
+
+// pendingRequests maps a CfsslSigningPolicy to the CertificateRequests
+// awaiting approval, so that they are evaluated when policies change.
+func (r *CertificateRequestApproverReconciler) pendingRequests(obj client.Object) []reconcile.Request {
+	crs := &cmapi.CertificateRequestList{}
+	if err := r.List(context.Background(), crs); err != nil {
+		r.Log.Error(err, "failed to list CertificateRequests")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range crs.Items {
+		if !pendingApproval(&crs.Items[i]) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Namespace: crs.Items[i].Namespace,
+				Name:      crs.Items[i].Name,
+			},
+		})
+	}
+
+	return requests
+}
+
+func (r *CertificateRequestApproverReconciler) setApproval(
+	ctx context.Context,
+	cr *cmapi.CertificateRequest,
+	conditionType cmapi.CertificateRequestConditionType,
+	message string,
+	args ...interface{},
+) error {
+	completeMessage := fmt.Sprintf(message, args...)
+
+	eventType := core.EventTypeNormal
+	if conditionType == cmapi.CertificateRequestConditionDenied {
+		eventType = core.EventTypeWarning
+	}
+	r.Recorder.Event(cr, eventType, string(conditionType), completeMessage)
+
+	cmutil.SetCertificateRequestCondition(cr, conditionType, cmmetav1.ConditionTrue, approverReason, completeMessage)
+
+	return r.Status().Update(ctx, cr)
+}
+
+// pendingApproval returns whether the CertificateRequest is for a cfssl
+// issuer and has been neither approved nor denied.
+func pendingApproval(cr *cmapi.CertificateRequest) bool {
+	return cr.Spec.IssuerRef.Group == cfsslv1beta1.GroupVersion.Group &&
+		!cmutil.CertificateRequestIsApproved(cr) &&
+		!cmutil.CertificateRequestIsDenied(cr)
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	cmutil "github.com/cert-manager/cert-manager/pkg/api/util"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("CertificateRequest Approver", func() {
+	const timeout = time.Second * 30
+	const interval = time.Second * 1
+
+	It("Should approve and deny CertificateRequests according to policies", func() {
+		policy := &cfsslv1beta1.CfsslSigningPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "cfssl-signing-policy",
+			},
+			Spec: cfsslv1beta1.CfsslSigningPolicySpec{
+				IssuerRefs: []cfsslv1beta1.PolicyIssuerReference{
+					{Kind: "CfsslIssuer", Name: "cfssl-issuer-policy"},
+				},
+				Namespaces:  []string{namespace},
+				MaxDuration: &metav1.Duration{Duration: 24 * time.Hour},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), policy)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), policy)
+		}()
+
+		tests := []struct {
+			csr      *cmapi.CertificateRequest
+			approved bool
+		}{
+			{
+				csr:      createCSR("csr-policy-approved", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-policy"),
+				approved: true,
+			},
+			{
+				csr:      createCSR("csr-policy-denied", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-policy"),
+				approved: false,
+			},
+		}
+		tests[0].csr.Spec.Duration = &metav1.Duration{Duration: time.Hour}
+
+		for _, tc := range tests {
+			key := types.NamespacedName{
+				Namespace: tc.csr.Namespace,
+				Name:      tc.csr.Name,
+			}
+			Expect(k8sClient.Create(context.Background(), tc.csr)).Should(Succeed())
+			defer func() {
+				_ = k8sClient.Delete(context.Background(), tc.csr)
+			}()
+
+			Eventually(func() bool {
+				f := &cmapi.CertificateRequest{}
+				if err := k8sClient.Get(context.Background(), key, f); err != nil {
+					return false
+				}
+
+				if tc.approved {
+					return cmutil.CertificateRequestIsApproved(f)
+				}
+				return cmutil.CertificateRequestIsDenied(f)
+			}, timeout, interval).Should(BeTrue())
+		}
+	})
+
+	It("Should ignore CertificateRequests no policy applies to", func() {
+		csr := createCSR("csr-no-policy", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-no-policy")
+		key := types.NamespacedName{
+			Namespace: csr.Namespace,
+			Name:      csr.Name,
+		}
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+
+		Consistently(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			return cmutil.CertificateRequestIsApproved(f) || cmutil.CertificateRequestIsDenied(f)
+		}, time.Second*5, interval).Should(BeFalse())
+	})
+})
@@ -18,21 +18,37 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
 
+	"github.com/OpenSource-THG/cfssl-issuer/audit"
+	"github.com/OpenSource-THG/cfssl-issuer/policy"
 	"github.com/OpenSource-THG/cfssl-issuer/provisioners"
+	"github.com/OpenSource-THG/cfssl-issuer/tracing"
 	cmutil "github.com/cert-manager/cert-manager/pkg/api/util"
 	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	cmmetav1 "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // CertificateRequestReconciler reconciles a LocalCA object
@@ -41,13 +57,57 @@ type CertificateRequestReconciler struct {
 	Log      logr.Logger
 	Clock    clock.Clock
 	Recorder record.EventRecorder
+
+	// MaxConcurrentReconciles is the number of CertificateRequests
+	// reconciled concurrently.
+	MaxConcurrentReconciles int
+
+	// DisableApprovalCheck signs CertificateRequests without waiting for
+	// them to be approved, for clusters without approval controllers.
+	DisableApprovalCheck bool
+
+	// Auditor records the issued certificates. Nil disables auditing.
+	Auditor *audit.Auditor
+
+	// Provisioners caches the provisioners built for issuers.
+	Provisioners *provisioners.Cache
+
+	// ClusterResourceNamespace is the namespace Secrets referenced by
+	// CfsslClusterIssuers are read from.
+	ClusterResourceNamespace string
 }
 
-// +kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests,verbs=get;list;watch;update
+const (
+	waitingForApproval = "Waiting for the CertificateRequest to be approved"
+
+	// durationMismatchReason is the reason of the Warning event recorded
+	// when a certificate is not valid for the requested duration.
+	durationMismatchReason = "DurationMismatch"
+	// verificationFailedReason is the reason of the Warning event recorded
+	// when a certificate fails verification under the warn verification
+	// mode.
+	verificationFailedReason = "VerificationFailed"
+)
+
+// errIssuerNotReady is returned by loadProvisioner for issuers which are not
+// Ready, e.g. because their Cfssl Server failed the health check.
+var errIssuerNotReady = errors.New("issuer is not Ready")
+
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
-func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "CertificateRequestReconciler.Reconcile", trace.WithAttributes(
+		attribute.String("certificaterequest.namespace", req.Namespace),
+		attribute.String("certificaterequest.name", req.Name),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	return r.reconcile(ctx, req)
+}
+
+func (r *CertificateRequestReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("certificaterequest", req.NamespacedName)
 
 	// Fetch the CertificateRequest resource being reconciled
@@ -91,70 +151,407 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, nil
 	}
 
+	// Mark the CertificateRequest as Denied if an approver denied it
+	if cmutil.CertificateRequestIsDenied(cr) {
+		log.Info("CertificateRequest has been denied. Ignoring.")
+		recordResult(cr, resultDenied, nil)
+		return ctrl.Result{}, r.setStatus(ctx, cr, cmmetav1.ConditionFalse, cmapi.CertificateRequestReasonDenied,
+			"The CertificateRequest was denied by an approval controller")
+	}
+	// Wait for the CertificateRequest to be approved before signing it
+	if !r.DisableApprovalCheck && !cmutil.CertificateRequestIsApproved(cr) {
+		log.V(4).Info("CertificateRequest has not been approved yet. Ignoring.")
+		if hasReadyCondition(cr, cmapi.CertificateRequestReasonPending, waitingForApproval) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, r.setStatus(ctx, cr, cmmetav1.ConditionFalse, cmapi.CertificateRequestReasonPending,
+			waitingForApproval)
+	}
+
+	// Wait for the next retry if signing failed before, unless the issuer
+	// changed or became Ready since
+	if state := retryStateOf(cr); state.nextRetry.After(r.Clock.Now()) {
+		wait := state.nextRetry.Sub(r.Clock.Now())
+		if issuer, _, err := r.issuerFor(ctx, cr); err != nil || issuerRevision(issuer) == state.issuerRevision {
+			log.V(4).Info("waiting to retry signing", "after", wait)
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+		log.V(4).Info("issuer changed since the last attempt, retrying signing now")
+	}
+
 	// Load the configured provisioner
-	provisioner, err := LoadProvisioner(req, cr, log)
+	provisioner, spec, err := r.loadProvisioner(ctx, cr)
 	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to load %s provisioner", cr.Spec.IssuerRef.Kind), "name", cr.Spec.IssuerRef.Name)
 		_ = r.setStatus(ctx, cr, cmmetav1.ConditionFalse, cmapi.CertificateRequestReasonPending,
 			"%s resource %s is not Ready", cr.Spec.IssuerRef.Kind, cr.Spec.IssuerRef.Name)
 		return ctrl.Result{}, err
 	}
 
-	// Sign the SR and return the cert and ca
-	signedPEM, ca, err := provisioner.Sign(cr.Spec.Request)
+	// Sign the SR with the profile selected by the issuer and return the
+	// cert and ca
+	// cert-manager only gives up on requests which failed or were denied by
+	// an approver, so requests selecting a profile the issuer does not allow
+	// are failed rather than left pending forever
+	profile, err := policy.Profile(*spec, cr)
+	if err != nil {
+		log.Info("CertificateRequest selects a profile the issuer does not allow. Failing.", "reason", err.Error())
+		recordResult(cr, resultFailed, nil)
+		return ctrl.Result{}, r.setStatus(ctx, cr, cmmetav1.ConditionFalse, cmapi.CertificateRequestReasonFailed,
+			"Rejected by %s %s: %v", cr.Spec.IssuerRef.Kind, cr.Spec.IssuerRef.Name, err)
+	}
+	log = log.WithValues("profile", profile)
+	signOpts := []provisioners.SignOption{
+		provisioners.WithProfile(profile),
+		provisioners.WithUsages(cr.Spec.Usages, cr.Spec.IsCA),
+	}
+	if cr.Spec.Duration != nil {
+		signOpts = append(signOpts, provisioners.WithDuration(cr.Spec.Duration.Duration))
+	}
+	res, err := provisioner.Sign(ctx, cr.Spec.Request, signOpts...)
 	if err != nil {
+		// Requeue requests over the rate limits of the issuer. Throttling is
+		// reported by metrics rather than by status updates, which would
+		// add to the load during mass renewals.
+		var throttledErr *provisioners.ThrottledError
+		if errors.As(err, &throttledErr) {
+			log.V(1).Info("signing throttled", "reason", throttledErr.Reason, "after", throttledErr.RetryAfter)
+			return ctrl.Result{RequeueAfter: throttledErr.RetryAfter}, nil
+		}
+
+		// Wait for the circuit breaker of the issuer to let requests through.
+		// The status is only updated once, rather than on every requeue
+		// while the breaker is open.
+		var openErr *provisioners.CircuitOpenError
+		if errors.As(err, &openErr) {
+			log.Info("circuit breaker is open, not signing", "after", openErr.RetryAfter)
+			message := fmt.Sprintf("The cfssl backend of %s %s is tripped", cr.Spec.IssuerRef.Kind, cr.Spec.IssuerRef.Name)
+			if hasReadyCondition(cr, cmapi.CertificateRequestReasonPending, message) {
+				return ctrl.Result{RequeueAfter: openErr.RetryAfter}, nil
+			}
+			return ctrl.Result{RequeueAfter: openErr.RetryAfter}, r.setStatus(ctx, cr, cmmetav1.ConditionFalse,
+				cmapi.CertificateRequestReasonPending, "%s", message)
+		}
+
+		var serr *provisioners.SignError
+		if errors.As(err, &serr) {
+			log = log.WithValues("class", serr.Class, "code", serr.Code)
+		}
 		log.Error(err, "failed to sign certificate request")
-		reason := cmapi.CertificateRequestReasonPending
+
+		// Permanent errors fail the request, leaving retries to cert-manager
 		if !provisioners.Retryable(err) {
-			reason = cmapi.CertificateRequestReasonFailed
-		}
-		if updateErr := r.setStatus(ctx, cr, cmmetav1.ConditionFalse, reason,
-			"Failed to sign certificate request: %v", err); updateErr != nil {
-			// so we log the update error
-			err = updateErr
+			recordResult(cr, resultFailed, err)
+			return ctrl.Result{}, r.setStatus(ctx, cr, cmmetav1.ConditionFalse, cmapi.CertificateRequestReasonFailed,
+				"Failed to sign certificate request: %v", err)
 		}
+		return r.retry(ctx, cr, err)
+	}
+
+	cr.Status.Certificate = res.Certificate
+	cr.Status.CA = res.CA
+	r.checkDuration(cr, *spec, res)
+	if res.VerifyErr != nil {
+		log.Info("issued certificate failed verification", "reason", res.VerifyErr.Error())
+		r.Recorder.Eventf(cr, core.EventTypeWarning, verificationFailedReason,
+			"Certificate failed verification: %v", res.VerifyErr)
+	}
+
+	message := fmt.Sprintf("Certificate Issued by %s", res.Endpoint)
+	if profile != "" {
+		message += " with profile " + profile
+	}
+	if err := r.setStatus(ctx, cr, cmmetav1.ConditionTrue, cmapi.CertificateRequestReasonIssued, "%s", message); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	cr.Status.Certificate = signedPEM
-	cr.Status.CA = ca
+	// Only count and audit certificates handed to cert-manager, as the
+	// request is signed again if its status fails to be updated
+	recordResult(cr, resultIssued, nil)
+	r.audit(cr, res)
 
-	return ctrl.Result{}, r.setStatus(ctx, cr, cmmetav1.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Certificate Issued")
+	// Drop the retry state of the request, which is of no use once issued
+	patch := client.MergeFrom(cr.DeepCopy())
+	if clearRetryState(cr) {
+		return ctrl.Result{}, r.Patch(ctx, cr, patch)
+	}
+	return ctrl.Result{}, nil
 }
 
-func LoadProvisioner(req ctrl.Request, cr *cmapi.CertificateRequest, log logr.Logger) (provisioners.Provisioner, error) {
-	var p provisioners.Provisioner
-	var ok bool
+// retry records a failed signing attempt on the CertificateRequest and
+// schedules the next one according to the retry policy of its issuer. The
+// request fails once the policy gives up. Timed out attempts are reported
+// with the Timeout reason rather than Pending.
+func (r *CertificateRequestReconciler) retry(ctx context.Context, cr *cmapi.CertificateRequest, signErr error) (ctrl.Result, error) {
+	log := r.Log.WithValues("certificaterequest", client.ObjectKeyFromObject(cr))
+
+	now := r.Clock.Now()
+	state := retryStateOf(cr)
+	state.attempts++
+	state.nextRetry = time.Time{}
+	state.issuerRevision = ""
 
-	issuerKey := types.NamespacedName{
-		Name: cr.Spec.IssuerRef.Name,
+	var policy *cfsslv1beta1.RetryPolicy
+	if issuer, spec, err := r.issuerFor(ctx, cr); err != nil {
+		log.Error(err, "failed to retrieve issuer, using the default retry policy")
+	} else {
+		policy = spec.RetryPolicy
+		state.issuerRevision = issuerRevision(issuer)
 	}
+	retrier := newRetrier(policy)
 
-	kind := cr.Spec.IssuerRef.Kind
+	giveUp := retrier.giveUp(cr.CreationTimestamp.Time, now, state.attempts)
+	delay := retrier.backoff(state.attempts)
+	if !giveUp {
+		state.nextRetry = now.Add(delay)
+	}
+
+	state.apply(cr)
+	if err := r.Update(ctx, cr); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if giveUp {
+		recordResult(cr, resultFailed, signErr)
+		return ctrl.Result{}, r.setStatus(ctx, cr, cmmetav1.ConditionFalse, cmapi.CertificateRequestReasonFailed,
+			"Giving up after %d attempts: %v", state.attempts, signErr)
+	}
+
+	recordResult(cr, resultRetrying, signErr)
+	var serr *provisioners.SignError
+	reason := cmapi.CertificateRequestReasonPending
+	if errors.As(signErr, &serr) && serr.Class == provisioners.Timeout {
+		reason = signTimeoutReason
+	}
+	return ctrl.Result{RequeueAfter: delay}, r.setStatus(ctx, cr, cmmetav1.ConditionFalse, reason,
+		"Failed to sign certificate request (attempt %d, retrying in %s): %v", state.attempts, delay, signErr)
+}
+
+// audit records the certificate issued for the CertificateRequest.
+func (r *CertificateRequestReconciler) audit(cr *cmapi.CertificateRequest, res *provisioners.SignResult) {
+	if r.Auditor == nil {
+		return
+	}
+
+	record, err := audit.NewRecord(cr, res.Certificate, res.Endpoint, r.Clock.Now())
+	if err != nil {
+		r.Log.Error(err, "failed to create audit record", "certificaterequest", client.ObjectKeyFromObject(cr))
+		return
+	}
+	r.Auditor.Record(record)
+}
+
+// checkDuration records a Warning event on the CertificateRequest if the
+// issued certificate is not valid for the requested duration, as when it is
+// capped by the MaxDuration of the issuer. Only local issuers honor the
+// requested duration, a Cfssl Server signs with the expiry of the profile.
+func (r *CertificateRequestReconciler) checkDuration(cr *cmapi.CertificateRequest, spec cfsslv1beta1.CfsslIssuerSpec,
+	res *provisioners.SignResult) {
+	if cr.Spec.Duration == nil || spec.Mode != cfsslv1beta1.LocalMode {
+		return
+	}
+
+	cert, err := pki.DecodeX509CertificateBytes(res.Certificate)
+	if err != nil {
+		r.Log.Error(err, "failed to decode issued certificate", "certificaterequest", client.ObjectKeyFromObject(cr))
+		return
+	}
 
-	switch kind {
+	requested := cr.Spec.Duration.Duration
+	actual := cert.NotAfter.Sub(cert.NotBefore)
+	if diff := actual - requested; diff >= time.Second || diff <= -time.Second {
+		r.Recorder.Eventf(cr, core.EventTypeWarning, durationMismatchReason,
+			"Certificate is valid for %s instead of the requested %s", actual, requested)
+	}
+}
+
+// issuerFor returns the issuer the CertificateRequest references and its
+// spec.
+func (r *CertificateRequestReconciler) issuerFor(ctx context.Context, cr *cmapi.CertificateRequest) (client.Object, *cfsslv1beta1.CfsslIssuerSpec, error) {
+	key := types.NamespacedName{Name: cr.Spec.IssuerRef.Name}
+
+	switch kind := cr.Spec.IssuerRef.Kind; kind {
 	case "CfsslIssuer":
-		issuerKey.Namespace = req.NamespacedName.Namespace
+		key.Namespace = cr.Namespace
+		issuer := &cfsslv1beta1.CfsslIssuer{}
+		if err := r.Get(ctx, key, issuer); err != nil {
+			return nil, nil, err
+		}
+		return issuer, &issuer.Spec, nil
 	case "CfsslClusterIssuer":
+		issuer := &cfsslv1beta1.CfsslClusterIssuer{}
+		if err := r.Get(ctx, key, issuer); err != nil {
+			return nil, nil, err
+		}
+		return issuer, &issuer.Spec, nil
 	default:
-		return nil, fmt.Errorf("unknown kind %s", kind)
+		return nil, nil, fmt.Errorf("unknown kind %s", kind)
+	}
+}
+
+// loadProvisioner returns the provisioner and spec of the issuer the
+// CertificateRequest references, which must be Ready. The provisioner is
+// built from the issuer and probed if it has not been cached for the
+// current generation and referenced Secrets, e.g. after a restart of the
+// controller.
+func (r *CertificateRequestReconciler) loadProvisioner(ctx context.Context, cr *cmapi.CertificateRequest) (
+	_ provisioners.Provisioner, _ *cfsslv1beta1.CfsslIssuerSpec, err error,
+) {
+	ctx, span := tracing.Tracer().Start(ctx, "LoadProvisioner", trace.WithAttributes(
+		attribute.String("issuer.kind", cr.Spec.IssuerRef.Kind),
+		attribute.String("issuer.name", cr.Spec.IssuerRef.Name),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	issuer, spec, err := r.issuerFor(ctx, cr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isReady(issuer) {
+		return nil, nil, errIssuerNotReady
+	}
+	if err := validateCfsslIssuerSpec(*spec); err != nil {
+		return nil, nil, err
 	}
 
-	p, ok = provisioners.Load(issuerKey)
-	if !ok {
-		err := fmt.Errorf("provisioner %s not found", issuerKey)
-		log.Error(err, fmt.Sprintf("failed to retrieve %s resource", kind), "name", issuerKey)
-		return nil, err
+	kind := cr.Spec.IssuerRef.Kind
+	namespace := cr.Namespace
+	if kind == "CfsslClusterIssuer" {
+		namespace = r.ClusterResourceNamespace
+	}
+	opts, err := provisionerOptions(ctx, r.Client, namespace, *spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", resolveSecretsFailure, err)
+	}
+	opts = append(opts, issuerOptions(kind, client.ObjectKeyFromObject(issuer), *spec)...)
+	if p, ok := r.Provisioners.Get(issuer, opts...); ok {
+		return p, spec, nil
 	}
 
-	return p, nil
+	p, err := r.Provisioners.Build(*spec, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.Probe(ctx); err != nil {
+		r.Provisioners.Discard(p)
+		return nil, nil, fmt.Errorf("%s: %w", healthCheckFailure, err)
+	}
+	return r.Provisioners.Store(kind, issuer, p), spec, nil
 }
 
 func (r *CertificateRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &cmapi.CertificateRequest{},
+		issuerRefIndex, indexIssuerRef); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&cmapi.CertificateRequest{}).
+		Watches(&source.Kind{Type: &cfsslv1beta1.CfsslIssuer{}},
+			handler.EnqueueRequestsFromMapFunc(r.pendingRequestsFor("CfsslIssuer")),
+			builder.WithPredicates(issuerChanged)).
+		Watches(&source.Kind{Type: &cfsslv1beta1.CfsslClusterIssuer{}},
+			handler.EnqueueRequestsFromMapFunc(r.pendingRequestsFor("CfsslClusterIssuer")),
+			builder.WithPredicates(issuerChanged)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
 
+// issuerRefIndex indexes CertificateRequests by the issuer they reference.
+const issuerRefIndex = "spec.issuerRef"
+
+// issuerRefKey returns the issuerRefIndex value of the issuer of the given
+// kind. The namespace of CfsslClusterIssuers is empty.
+func issuerRefKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// indexIssuerRef returns the issuerRefIndex values of a CertificateRequest
+// referencing one of our issuers.
+func indexIssuerRef(obj client.Object) []string {
+	cr, ok := obj.(*cmapi.CertificateRequest)
+	if !ok || cr.Spec.IssuerRef.Group != cfsslv1beta1.GroupVersion.Group {
+		return nil
+	}
+
+	switch kind := cr.Spec.IssuerRef.Kind; kind {
+	case "CfsslIssuer":
+		return []string{issuerRefKey(kind, cr.Namespace, cr.Spec.IssuerRef.Name)}
+	case "CfsslClusterIssuer":
+		return []string{issuerRefKey(kind, "", cr.Spec.IssuerRef.Name)}
+	default:
+		return nil
+	}
+}
+
+// issuerChanged passes updates of issuers that became Ready or whose spec
+// changed, after which pending CertificateRequests may be signed.
+var issuerChanged = predicate.Funcs{
+	CreateFunc:  func(event.CreateEvent) bool { return false },
+	DeleteFunc:  func(event.DeleteEvent) bool { return false },
+	GenericFunc: func(event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return e.ObjectNew.GetGeneration() != e.ObjectOld.GetGeneration() ||
+			!isReady(e.ObjectOld) && isReady(e.ObjectNew)
+	},
+}
+
+// isReady returns whether obj is a Ready issuer.
+func isReady(obj client.Object) bool {
+	issuer, ok := obj.(interface{ IsReady() bool })
+	return ok && issuer.IsReady()
+}
+
+// pendingRequestsFor returns a handler.MapFunc mapping an issuer of the given
+// kind to the CertificateRequests referencing it which have neither been
+// issued nor failed or denied.
+func (r *CertificateRequestReconciler) pendingRequestsFor(kind string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		opts := []client.ListOption{
+			client.MatchingFields{issuerRefIndex: issuerRefKey(kind, obj.GetNamespace(), obj.GetName())},
+		}
+		if obj.GetNamespace() != "" {
+			opts = append(opts, client.InNamespace(obj.GetNamespace()))
+		}
+
+		crs := &cmapi.CertificateRequestList{}
+		if err := r.List(context.Background(), crs, opts...); err != nil {
+			r.Log.Error(err, "failed to list CertificateRequests", "kind", kind, "issuer", client.ObjectKeyFromObject(obj))
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range crs.Items {
+			if !isPending(&crs.Items[i]) {
+				continue
+			}
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(&crs.Items[i]),
+			})
+		}
+
+		return requests
+	}
+}
+
+// isPending returns whether the CertificateRequest still awaits signing.
+func isPending(cr *cmapi.CertificateRequest) bool {
+	cond := cmutil.GetCertificateRequestCondition(cr, cmapi.CertificateRequestConditionReady)
+	if cond == nil {
+		return true
+	}
+	return cond.Status != cmmetav1.ConditionTrue &&
+		cond.Reason != cmapi.CertificateRequestReasonFailed &&
+		cond.Reason != cmapi.CertificateRequestReasonDenied
+}
+
+// hasReadyCondition returns whether the Ready condition of the
+// CertificateRequest has the given reason and message already.
+func hasReadyCondition(cr *cmapi.CertificateRequest, reason, message string) bool {
+	cond := cmutil.GetCertificateRequestCondition(cr, cmapi.CertificateRequestConditionReady)
+	return cond != nil && cond.Reason == reason && cond.Message == message
+}
+
 func (r *CertificateRequestReconciler) setStatus(
 	ctx context.Context,
 	cr *cmapi.CertificateRequest,
@@ -178,6 +575,12 @@ func (r *CertificateRequestReconciler) setStatus(
 		reason,
 		completeMessage,
 	)
+	// cert-manager backs off reissuing failed requests from their failure
+	// time
+	if reason == cmapi.CertificateRequestReasonFailed && cr.Status.FailureTime == nil {
+		now := metav1.NewTime(r.Clock.Now())
+		cr.Status.FailureTime = &now
+	}
 
 	if err := r.Status().Update(ctx, cr); err != nil {
 		return err
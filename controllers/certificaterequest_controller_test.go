@@ -2,17 +2,21 @@ package controllers
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/OpenSource-THG/cfssl-issuer/policy"
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
 	cmutil "github.com/cert-manager/cert-manager/pkg/api/util"
 	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const namespace = "default"
@@ -145,6 +149,7 @@ var _ = Describe("CertificateRequest Controller", func() {
 			Spec: cfsslv1beta1.CfsslIssuerSpec{
 				URL:      mockCfsslServer.URL,
 				CABundle: encodeCert(mockCfsslServer.Certificate()),
+				CAChain:  caBundle,
 			},
 		}
 
@@ -167,6 +172,20 @@ var _ = Describe("CertificateRequest Controller", func() {
 			_ = k8sClient.Delete(context.Background(), csr)
 		}()
 
+		By("Waiting for approval")
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady)
+			return cond != nil && cond.Reason == cmapi.CertificateRequestReasonPending && cond.Message == waitingForApproval
+		}, timeout, interval).Should(BeTrue())
+
+		By("Signing once approved")
+		setApprovalCondition(key, cmapi.CertificateRequestConditionApproved)
+
 		Eventually(func() bool {
 			f := &cmapi.CertificateRequest{}
 			err := k8sClient.Get(context.Background(), key, f)
@@ -183,6 +202,468 @@ var _ = Describe("CertificateRequest Controller", func() {
 
 	})
 
+	It("Should sign pending certificate requests once their issuer is fixed", func() {
+		issuerKey := types.NamespacedName{
+			Name:      "cfssl-issuer-fixed",
+			Namespace: namespace,
+		}
+		// The issuer fails validation without a CA bundle
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      issuerKey.Name,
+				Namespace: issuerKey.Namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:     mockCfsslServer.URL,
+				CAChain: caBundle,
+			},
+		}
+
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		csr := createCSR("csr-fixed", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-fixed")
+		key := types.NamespacedName{
+			Namespace: csr.Namespace,
+			Name:      csr.Name,
+		}
+
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+		setApprovalCondition(key, cmapi.CertificateRequestConditionApproved)
+
+		By("Waiting for the issuer")
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady)
+			return cond != nil && cond.Reason == cmapi.CertificateRequestReasonPending &&
+				strings.HasSuffix(cond.Message, "is not Ready")
+		}, timeout, interval).Should(BeTrue())
+
+		By("Signing once the issuer is fixed")
+		Eventually(func() error {
+			if err := k8sClient.Get(context.Background(), issuerKey, issuer); err != nil {
+				return err
+			}
+			issuer.Spec.CABundle = encodeCert(mockCfsslServer.Certificate())
+			return k8sClient.Update(context.Background(), issuer)
+		}).Should(Succeed())
+
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			return cmutil.CertificateRequestHasCondition(f, cmapi.CertificateRequestCondition{
+				Type:   cmapi.CertificateRequestConditionReady,
+				Status: cmmeta.ConditionTrue,
+				Reason: cmapi.CertificateRequestReasonIssued,
+			})
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should mark certificate request as failed when cfssl rejects it", func() {
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cfssl-issuer-rejecting",
+				Namespace: namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:      mockCfsslServer.URL,
+				CABundle: encodeCert(mockCfsslServer.Certificate()),
+				Profile:  mock.PolicyViolationProfile,
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		time.Sleep(time.Second * 2)
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		csr := createCSR("csr-rejected", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-rejecting")
+		key := types.NamespacedName{
+			Namespace: csr.Namespace,
+			Name:      csr.Name,
+		}
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+
+		setApprovalCondition(key, cmapi.CertificateRequestConditionApproved)
+
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady)
+			return cond != nil && cond.Reason == cmapi.CertificateRequestReasonFailed &&
+				strings.Contains(cond.Message, "cfssl error 5300")
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should fail certificate requests whose certificate does not chain to the CA bundle", func() {
+		// the mock signs with testdata/ca.pem, not the CA of its TLS
+		// certificate which the CA chain falls back to
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cfssl-issuer-unverified",
+				Namespace: namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:      mockCfsslServer.URL,
+				CABundle: encodeCert(mockCfsslServer.Certificate()),
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		time.Sleep(time.Second * 2)
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		csr := createCSR("csr-unverified", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-unverified")
+		key := types.NamespacedName{
+			Namespace: csr.Namespace,
+			Name:      csr.Name,
+		}
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+
+		setApprovalCondition(key, cmapi.CertificateRequestConditionApproved)
+
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady)
+			return cond != nil && cond.Reason == cmapi.CertificateRequestReasonFailed &&
+				strings.Contains(cond.Message, "certificate does not chain to the CA") && len(f.Status.Certificate) == 0
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should keep certificate requests pending while their issuer is unhealthy", func() {
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cfssl-issuer-unhealthy",
+				Namespace: namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:      mockCfsslServer.URL,
+				CABundle: encodeCert(mockCfsslServer.Certificate()),
+				Profile:  mock.UnknownProfile,
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		time.Sleep(time.Second * 2)
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		csr := createCSR("csr-unhealthy", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-unhealthy")
+		key := types.NamespacedName{
+			Namespace: csr.Namespace,
+			Name:      csr.Name,
+		}
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+
+		setApprovalCondition(key, cmapi.CertificateRequestConditionApproved)
+
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady)
+			return cond != nil && cond.Reason == cmapi.CertificateRequestReasonPending &&
+				strings.HasSuffix(cond.Message, "is not Ready") && len(f.Status.Certificate) == 0
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should fail profile overrides the issuer does not allow", func() {
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cfssl-issuer-overrides",
+				Namespace: namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:                     mockCfsslServer.URL,
+				CABundle:                encodeCert(mockCfsslServer.Certificate()),
+				CAChain:                 caBundle,
+				Profile:                 "client",
+				AllowedProfileOverrides: []string{"long-lived-client"},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		time.Sleep(time.Second * 2)
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		allowed := createCSR("csr-allowed-override", "certmanager.thg.io", "CfsslIssuer", issuer.Name)
+		allowed.Annotations = map[string]string{policy.ProfileAnnotation: "long-lived-client"}
+		denied := createCSR("csr-denied-override", "certmanager.thg.io", "CfsslIssuer", issuer.Name)
+		denied.Annotations = map[string]string{policy.ProfileAnnotation: "server"}
+		for _, csr := range []*cmapi.CertificateRequest{allowed, denied} {
+			csr := csr
+			Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+			defer func() {
+				_ = k8sClient.Delete(context.Background(), csr)
+			}()
+			setApprovalCondition(client.ObjectKeyFromObject(csr), cmapi.CertificateRequestConditionApproved)
+		}
+
+		Eventually(func() string {
+			f := &cmapi.CertificateRequest{}
+			_ = k8sClient.Get(context.Background(), client.ObjectKeyFromObject(allowed), f)
+			if cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady); cond != nil {
+				return cond.Message
+			}
+			return ""
+		}, timeout, interval).Should(HaveSuffix("with profile long-lived-client"))
+
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(denied), f); err != nil {
+				return false
+			}
+
+			// cert-manager gives up on requests whose Ready condition has
+			// the Failed reason, backing off from their failure time
+			cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady)
+			return cond != nil && cond.Status == cmmeta.ConditionFalse &&
+				cond.Reason == cmapi.CertificateRequestReasonFailed &&
+				strings.Contains(cond.Message, "allowedProfileOverrides") &&
+				f.Status.FailureTime != nil && len(f.Status.Certificate) == 0
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should give up retrying after the configured attempts", func() {
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cfssl-issuer-failing",
+				Namespace: namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:      mockCfsslServer.URL,
+				CABundle: encodeCert(mockCfsslServer.Certificate()),
+				Profile:  mock.ServerErrorProfile,
+				RetryPolicy: &cfsslv1beta1.RetryPolicy{
+					InitialBackoff: &metav1.Duration{Duration: time.Second},
+					MaxAttempts:    2,
+				},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		time.Sleep(time.Second * 2)
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		csr := createCSR("csr-retried", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-failing")
+		key := types.NamespacedName{
+			Namespace: csr.Namespace,
+			Name:      csr.Name,
+		}
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+
+		setApprovalCondition(key, cmapi.CertificateRequestConditionApproved)
+
+		By("Retrying after the first attempt")
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			return f.Annotations[signAttemptsAnnotation] == "1" && f.Annotations[nextRetryAnnotation] != ""
+		}, timeout, interval).Should(BeTrue())
+
+		By("Failing after the last attempt")
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady)
+			return cond != nil && cond.Reason == cmapi.CertificateRequestReasonFailed &&
+				strings.HasPrefix(cond.Message, "Giving up after 2 attempts") &&
+				f.Annotations[signAttemptsAnnotation] == "2"
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should retry right away once the issuer changes", func() {
+		issuerKey := types.NamespacedName{
+			Name:      "cfssl-issuer-changing",
+			Namespace: namespace,
+		}
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      issuerKey.Name,
+				Namespace: issuerKey.Namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:      mockCfsslServer.URL,
+				CABundle: encodeCert(mockCfsslServer.Certificate()),
+				CAChain:  caBundle,
+				Profile:  mock.ServerErrorProfile,
+				RetryPolicy: &cfsslv1beta1.RetryPolicy{
+					InitialBackoff: &metav1.Duration{Duration: time.Hour},
+				},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		time.Sleep(time.Second * 2)
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		csr := createCSR("csr-issuer-changed", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-changing")
+		key := types.NamespacedName{
+			Namespace: csr.Namespace,
+			Name:      csr.Name,
+		}
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+
+		setApprovalCondition(key, cmapi.CertificateRequestConditionApproved)
+
+		By("Waiting an hour to retry after the first attempt")
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			return f.Annotations[signAttemptsAnnotation] == "1" && f.Annotations[nextRetryAnnotation] != ""
+		}, timeout, interval).Should(BeTrue())
+
+		By("Signing once the issuer is fixed")
+		Eventually(func() error {
+			if err := k8sClient.Get(context.Background(), issuerKey, issuer); err != nil {
+				return err
+			}
+			issuer.Spec.Profile = ""
+			return k8sClient.Update(context.Background(), issuer)
+		}).Should(Succeed())
+
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			_, attempts := f.Annotations[signAttemptsAnnotation]
+			_, nextRetry := f.Annotations[nextRetryAnnotation]
+			return !attempts && !nextRetry && cmutil.CertificateRequestHasCondition(f, cmapi.CertificateRequestCondition{
+				Type:   cmapi.CertificateRequestConditionReady,
+				Status: cmmeta.ConditionTrue,
+				Reason: cmapi.CertificateRequestReasonIssued,
+			})
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should report timed out signing requests with the Timeout reason", func() {
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cfssl-issuer-slow",
+				Namespace: namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:         mockCfsslServer.URL,
+				CABundle:    encodeCert(mockCfsslServer.Certificate()),
+				Profile:     mock.SlowProfile,
+				SignTimeout: &metav1.Duration{Duration: 100 * time.Millisecond},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		time.Sleep(time.Second * 2)
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		csr := createCSR("csr-timeout", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-slow")
+		key := types.NamespacedName{
+			Namespace: csr.Namespace,
+			Name:      csr.Name,
+		}
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+
+		setApprovalCondition(key, cmapi.CertificateRequestConditionApproved)
+
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady)
+			return cond != nil && cond.Reason == signTimeoutReason &&
+				f.Annotations[signAttemptsAnnotation] == "1"
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should mark certificate request as denied when an approver denied it", func() {
+		cleanup := setupCfsslIssuer(namespace, "cfssl-issuer-denied")
+		defer func() {
+			_ = cleanup()
+		}()
+
+		csr := createCSR("csr-denied", "certmanager.thg.io", "CfsslIssuer", "cfssl-issuer-denied")
+		key := types.NamespacedName{
+			Namespace: csr.Namespace,
+			Name:      csr.Name,
+		}
+
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+
+		setApprovalCondition(key, cmapi.CertificateRequestConditionDenied)
+
+		Eventually(func() bool {
+			f := &cmapi.CertificateRequest{}
+			err := k8sClient.Get(context.Background(), key, f)
+			if err != nil {
+				return false
+			}
+
+			return cmutil.CertificateRequestHasCondition(f, cmapi.CertificateRequestCondition{
+				Type:   cmapi.CertificateRequestConditionReady,
+				Status: cmmeta.ConditionFalse,
+				Reason: cmapi.CertificateRequestReasonDenied,
+			}) && len(f.Status.Certificate) == 0
+		}, timeout, interval).Should(BeTrue())
+	})
+
 	It("Should mark certificate request as pending when referencing a deleted issuer", func() {
 		issuerKey := types.NamespacedName{
 			Name:      "cfssl-issuer-deleted",
@@ -247,8 +728,8 @@ func setupCfsslIssuer(namespace, name string) func() error {
 			Namespace: key.Namespace,
 		},
 		Spec: cfsslv1beta1.CfsslIssuerSpec{
-			URL:      "http://test",
-			CABundle: caBundle,
+			URL:      mockCfsslServer.URL,
+			CABundle: encodeCert(mockCfsslServer.Certificate()),
 		},
 	}
 	Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
@@ -278,3 +759,18 @@ func createCSR(name, group, kind, issuername string) *cmapi.CertificateRequest {
 		},
 	}
 }
+
+// setApprovalCondition sets the Approved or Denied condition on the
+// CertificateRequest, as an approval controller would.
+func setApprovalCondition(key types.NamespacedName, conditionType cmapi.CertificateRequestConditionType) {
+	Eventually(func() error {
+		cr := &cmapi.CertificateRequest{}
+		if err := k8sClient.Get(context.Background(), key, cr); err != nil {
+			return err
+		}
+
+		cmutil.SetCertificateRequestCondition(cr, conditionType, cmmeta.ConditionTrue,
+			"cfssl-issuer.test", "set by test")
+		return k8sClient.Status().Update(context.Background(), cr)
+	}).Should(Succeed())
+}
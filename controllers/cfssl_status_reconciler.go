@@ -8,6 +8,7 @@ import (
 	"github.com/go-logr/logr"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type cfsslClusterStatusReconciler struct {
@@ -33,7 +34,8 @@ func (r *cfsslClusterStatusReconciler) Update(ctx context.Context,
 	args ...interface{},
 ) error {
 	completeMessage := fmt.Sprintf(message, args...)
-	r.setCondition(status, reason, completeMessage)
+	r.setCondition(cfsslv1beta1.ConditionReady, status, reason, completeMessage)
+	recordIssuerStatus("CfsslClusterIssuer", client.ObjectKeyFromObject(r.issuer), r.issuer.Status)
 
 	// Fire an Event to additionally inform users of the change
 	eventType := core.EventTypeNormal
@@ -49,7 +51,7 @@ func (r *cfsslClusterStatusReconciler) Update(ctx context.Context,
 	return nil
 }
 
-// setCondition will set a 'condition' on the given cfsslv1beta1.CfsslIssuer resource.
+// setCondition will set a condition of the given type on the given cfsslv1beta1.CfsslIssuer resource.
 //
 //   - If no condition of the same type already exists, the condition will be
 //     inserted with the LastTransitionTime set to the current time.
@@ -58,10 +60,14 @@ func (r *cfsslClusterStatusReconciler) Update(ctx context.Context,
 //   - If a condition of the same type and different state already exists, the
 //     condition will be updated and the LastTransitionTime set to the current
 //     time.
-func (r *cfsslClusterStatusReconciler) setCondition(status cfsslv1beta1.ConditionStatus, reason, message string) {
+func (r *cfsslClusterStatusReconciler) setCondition(
+	conditionType cfsslv1beta1.ConditionType,
+	status cfsslv1beta1.ConditionStatus,
+	reason, message string,
+) {
 	now := meta.NewTime(r.Clock.Now())
 	c := cfsslv1beta1.CfsslIssuerCondition{
-		Type:               cfsslv1beta1.ConditionReady,
+		Type:               conditionType,
 		Status:             status,
 		Reason:             reason,
 		Message:            message,
@@ -71,7 +77,7 @@ func (r *cfsslClusterStatusReconciler) setCondition(status cfsslv1beta1.Conditio
 	// Search through existing conditions
 	for idx, cond := range r.issuer.Status.Conditions {
 		// Skip unrelated conditions
-		if cond.Type != cfsslv1beta1.ConditionReady {
+		if cond.Type != conditionType {
 			continue
 		}
 
@@ -96,7 +102,7 @@ func (r *cfsslClusterStatusReconciler) setCondition(status cfsslv1beta1.Conditio
 	// the new condition into the slice.
 	r.issuer.Status.Conditions = append(r.issuer.Status.Conditions, c)
 	r.logger.Info("setting lastTransitionTime for CfsslClusterIssuer condition", "condition",
-		cfsslv1beta1.ConditionReady, "time", now.Time)
+		conditionType, "time", now.Time)
 }
 
 type cfsslStatusReconciler struct {
@@ -120,7 +126,8 @@ func (r *cfsslStatusReconciler) Update(
 	args ...interface{},
 ) error {
 	completeMessage := fmt.Sprintf(message, args...)
-	r.setCondition(status, reason, completeMessage)
+	r.setCondition(cfsslv1beta1.ConditionReady, status, reason, completeMessage)
+	recordIssuerStatus("CfsslIssuer", client.ObjectKeyFromObject(r.issuer), r.issuer.Status)
 
 	// Fire an Event to additionally inform users of the change
 	eventType := core.EventTypeNormal
@@ -136,7 +143,7 @@ func (r *cfsslStatusReconciler) Update(
 	return nil
 }
 
-// setCondition will set a 'condition' on the given cfsslv1beta1.CfsslIssuer resource.
+// setCondition will set a condition of the given type on the given cfsslv1beta1.CfsslIssuer resource.
 //
 //   - If no condition of the same type already exists, the condition will be
 //     inserted with the LastTransitionTime set to the current time.
@@ -145,10 +152,14 @@ func (r *cfsslStatusReconciler) Update(
 //   - If a condition of the same type and different state already exists, the
 //     condition will be updated and the LastTransitionTime set to the current
 //     time.
-func (r *cfsslStatusReconciler) setCondition(status cfsslv1beta1.ConditionStatus, reason, message string) {
+func (r *cfsslStatusReconciler) setCondition(
+	conditionType cfsslv1beta1.ConditionType,
+	status cfsslv1beta1.ConditionStatus,
+	reason, message string,
+) {
 	now := meta.NewTime(r.Clock.Now())
 	c := cfsslv1beta1.CfsslIssuerCondition{
-		Type:               cfsslv1beta1.ConditionReady,
+		Type:               conditionType,
 		Status:             status,
 		Reason:             reason,
 		Message:            message,
@@ -158,7 +169,7 @@ func (r *cfsslStatusReconciler) setCondition(status cfsslv1beta1.ConditionStatus
 	// Search through existing conditions
 	for idx, cond := range r.issuer.Status.Conditions {
 		// Skip unrelated conditions
-		if cond.Type != cfsslv1beta1.ConditionReady {
+		if cond.Type != conditionType {
 			continue
 		}
 
@@ -182,5 +193,5 @@ func (r *cfsslStatusReconciler) setCondition(status cfsslv1beta1.ConditionStatus
 	// If we've not found an existing condition of this type, we simply insert
 	// the new condition into the slice.
 	r.issuer.Status.Conditions = append(r.issuer.Status.Conditions, c)
-	r.logger.Info("setting lastTransitionTime for CfsslIssuer condition", "condition", cfsslv1beta1.ConditionReady, "time", now.Time)
+	r.logger.Info("setting lastTransitionTime for CfsslIssuer condition", "condition", conditionType, "time", now.Time)
 }
@@ -17,12 +17,21 @@ package controllers
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-logr/logr"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	certmanagerv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
 	"github.com/OpenSource-THG/cfssl-issuer/provisioners"
@@ -31,6 +40,7 @@ import (
 const errorReason = "Error"
 const errorValidation = "Validation"
 const initProvisionerFailure = "failed to initialize provisioner"
+const caDiscoveryFailure = "failed to discover CA chain"
 
 // CfsslClusterIssuerReconciler reconciles a CfsslClusterIssuer object
 type CfsslClusterIssuerReconciler struct {
@@ -38,6 +48,17 @@ type CfsslClusterIssuerReconciler struct {
 	Log      logr.Logger
 	Clock    clock.Clock
 	Recorder record.EventRecorder
+
+	// HealthCheckInterval is the interval at which the Cfssl Server is
+	// probed. Zero disables periodic health checks.
+	HealthCheckInterval time.Duration
+
+	// Provisioners caches the provisioners built for issuers.
+	Provisioners *provisioners.Cache
+
+	// ClusterResourceNamespace is the namespace Secrets referenced by
+	// CfsslClusterIssuers are read from.
+	ClusterResourceNamespace string
 }
 
 // +kubebuilder:rbac:groups=certmanager.thg.io,resources=cfsslclusterissuers,verbs=get;list;watch;create;update;patch;delete
@@ -49,8 +70,12 @@ func (r *CfsslClusterIssuerReconciler) Reconcile(ctx context.Context, req ctrl.R
 	// Fetch the Cfssl resource being synced
 	cfssl := &certmanagerv1beta1.CfsslClusterIssuer{}
 	if err := r.Client.Get(ctx, req.NamespacedName, cfssl); err != nil {
+		if apierrors.IsNotFound(err) {
+			forgetIssuer(r.Provisioners, "CfsslClusterIssuer", req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
 		log.Error(err, "failed to retrieve Cfssl resource")
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		return ctrl.Result{}, err
 	}
 
 	statusReconciler := newCfsslClusterStatusReconciler(r, cfssl, log)
@@ -60,21 +85,104 @@ func (r *CfsslClusterIssuerReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, err
 	}
 
-	p, err := provisioners.New(cfssl.Spec)
+	opts, err := provisionerOptions(ctx, r.Client, r.ClusterResourceNamespace, cfssl.Spec)
 	if err != nil {
-		log.Error(err, initProvisionerFailure)
-		_ = statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, errorReason, initProvisionerFailure)
+		log.Error(err, resolveSecretsFailure)
+		_ = statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, errorReason, "%s: %v", resolveSecretsFailure, err)
+		return ctrl.Result{}, err
+	}
+
+	breaker := provisioners.CircuitBreakerFor("CfsslClusterIssuer", req.NamespacedName, cfssl.Spec.CircuitBreaker)
+	statusReconciler.setCondition(circuitCondition(breaker.State()))
+	opts = append(opts, issuerOptions("CfsslClusterIssuer", req.NamespacedName, cfssl.Spec)...)
+
+	// Reuse the cached provisioner unless the issuer or the Secrets it
+	// references changed, keeping connections and a discovered CA chain
+	p, cached := r.Provisioners.Get(cfssl, opts...)
+	if !cached {
+		if p, err = r.Provisioners.Build(cfssl.Spec, opts...); err != nil {
+			log.Error(err, initProvisionerFailure)
+			_ = statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, errorReason, initProvisionerFailure)
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := p.Probe(ctx); err != nil {
+		log.Error(err, healthCheckFailure)
+		cfssl.Status.Endpoints = p.EndpointStatus()
+		if !cached {
+			r.Provisioners.Discard(p)
+		}
+		if updateErr := statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, probeFailureReason(err),
+			"%s: %v", healthCheckFailure, err); updateErr != nil || r.HealthCheckInterval == 0 {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: r.HealthCheckInterval}, nil
+	}
+
+	// Only cache provisioners which passed the health check, as they are
+	// used to sign certificates
+	if !cached {
+		p = r.Provisioners.Store("CfsslClusterIssuer", cfssl, p)
+	}
+
+	if err := p.RefreshCAChain(ctx); err != nil {
+		log.Error(err, caDiscoveryFailure)
+		cfssl.Status.Endpoints = p.EndpointStatus()
+		_ = statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, errorReason, "%s: %v", caDiscoveryFailure, err)
 		return ctrl.Result{}, err
 	}
 
-	provisioners.Store(req.NamespacedName, p)
+	cfssl.Status.Endpoints = p.EndpointStatus()
+	cfssl.Status.CA = p.CAStatus()
 
-	return ctrl.Result{}, statusReconciler.Update(
+	// Requeue to check the health, refresh a discovered CA chain and show
+	// the breaker half-opening
+	requeue := requeueAfter(r.HealthCheckInterval, p.CAChainRefreshInterval(), breaker.RetryAfter())
+	return ctrl.Result{RequeueAfter: requeue}, statusReconciler.Update(
 		ctx, certmanagerv1beta1.ConditionTrue, "Verified", "CfsslClusterIssuer verified and ready to sign certificates")
 }
 
 func (r *CfsslClusterIssuerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := indexReferences(context.Background(), mgr, &certmanagerv1beta1.CfsslClusterIssuer{},
+		func(obj client.Object) certmanagerv1beta1.CfsslIssuerSpec {
+			return obj.(*certmanagerv1beta1.CfsslClusterIssuer).Spec
+		}); err != nil {
+		return err
+	}
+
+	// Only objects in the cluster resource namespace can be referenced
+	inNamespace := builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetNamespace() == r.ClusterResourceNamespace
+	}))
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&certmanagerv1beta1.CfsslClusterIssuer{}).
+		Watches(&source.Kind{Type: &core.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.issuersFor(secretRefsField)),
+			builder.OnlyMetadata, inNamespace).
+		Watches(&source.Kind{Type: &core.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.issuersFor(configMapRefsField)),
+			builder.OnlyMetadata, inNamespace).
+		Watches(circuitChanges("CfsslClusterIssuer"), &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
+
+// issuersFor returns a handler.MapFunc mapping a Secret or ConfigMap in the
+// cluster resource namespace to the CfsslClusterIssuers referencing it by the
+// given index field.
+func (r *CfsslClusterIssuerReconciler) issuersFor(field string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		issuers := &certmanagerv1beta1.CfsslClusterIssuerList{}
+		if err := r.List(context.Background(), issuers, client.MatchingFields{field: obj.GetName()}); err != nil {
+			r.Log.Error(err, "failed to list CfsslClusterIssuers")
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range issuers.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: issuers.Items[i].Name},
+			})
+		}
+
+		return requests
+	}
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -10,6 +11,7 @@ import (
 	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const testURL = "http://test"
@@ -27,8 +29,8 @@ var _ = Describe("CfsslClusterIssuer Controller", func() {
 				Name: key.Name,
 			},
 			Spec: cfsslv1beta1.CfsslIssuerSpec{
-				URL:      testURL,
-				CABundle: caBundle,
+				URL:      mockCfsslServer.URL,
+				CABundle: encodeCert(mockCfsslServer.Certificate()),
 			},
 		}
 		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
@@ -44,7 +46,7 @@ var _ = Describe("CfsslClusterIssuer Controller", func() {
 		}, timeout, interval).Should(BeTrue())
 
 		By("Updating the scope")
-		fetched.Spec.URL = testURL + ".new.url"
+		fetched.Spec.Profile = "new-profile"
 
 		Expect(k8sClient.Update(context.Background(), fetched)).Should(Succeed())
 		time.Sleep(time.Second * 2)
@@ -78,10 +80,28 @@ var _ = Describe("CfsslClusterIssuer Controller", func() {
 				},
 			}
 
-			Expect(k8sClient.Create(context.Background(), missingBundle)).ShouldNot(Succeed())
+			Expect(k8sClient.Create(context.Background(), missingBundle)).Should(Succeed())
 			defer func() {
 				_ = k8sClient.Delete(context.Background(), missingBundle)
 			}()
+
+			Eventually(func() bool {
+				f := &cfsslv1beta1.CfsslClusterIssuer{}
+				err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(missingBundle), f)
+				if err != nil || f == nil {
+					return false
+				}
+
+				for _, cond := range f.Status.Conditions {
+					if cond.Type == cfsslv1beta1.ConditionReady &&
+						cond.Status == cfsslv1beta1.ConditionFalse &&
+						cond.Reason == errorValidation {
+						return true
+					}
+				}
+
+				return false
+			}).Should(BeTrue())
 		})
 
 		Context("Requiring validCABundle", func() {
@@ -114,8 +134,8 @@ var _ = Describe("CfsslClusterIssuer Controller", func() {
 					}
 
 					if cond.Status == cfsslv1beta1.ConditionFalse &&
-						cond.Reason == errorReason &&
-						cond.Message == initProvisionerFailure {
+						cond.Reason == errorValidation &&
+						strings.Contains(cond.Message, "spec.caBundle") {
 						return true
 					}
 				}
@@ -18,14 +18,22 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	certmanagerv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
 	"github.com/OpenSource-THG/cfssl-issuer/provisioners"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
 	"github.com/go-logr/logr"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // CfsslIssuerReconciler reconciles a CfsslIssuer object
@@ -34,10 +42,19 @@ type CfsslIssuerReconciler struct {
 	Log      logr.Logger
 	Clock    clock.Clock
 	Recorder record.EventRecorder
+
+	// HealthCheckInterval is the interval at which the Cfssl Server is
+	// probed. Zero disables periodic health checks.
+	HealthCheckInterval time.Duration
+
+	// Provisioners caches the provisioners built for issuers.
+	Provisioners *provisioners.Cache
 }
 
 // +kubebuilder:rbac:groups=certmanager.thg.io,resources=cfsslissuers,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=certmanager.thg.io,resources=cfsslissuers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 
 // Reconcile reconciles a given CfsslIssuer resource
 func (r *CfsslIssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -63,7 +80,7 @@ func (r *CfsslIssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	} else {
 		if containsString(cfssl.ObjectMeta.Finalizers, finalizer) {
 			// Remove issuer from provisioners
-			provisioners.Remove(req.NamespacedName)
+			forgetIssuer(r.Provisioners, "CfsslIssuer", req.NamespacedName)
 			cfssl.ObjectMeta.Finalizers = removeString(cfssl.ObjectMeta.Finalizers, finalizer)
 			if err := r.Update(ctx, cfssl); err != nil {
 				return ctrl.Result{}, err
@@ -80,35 +97,179 @@ func (r *CfsslIssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, err
 	}
 
-	p, err := provisioners.New(cfssl.Spec)
+	opts, err := provisionerOptions(ctx, r.Client, req.Namespace, cfssl.Spec)
 	if err != nil {
-		log.Error(err, initProvisionerFailure)
-		_ = statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, errorReason, initProvisionerFailure)
+		log.Error(err, resolveSecretsFailure)
+		_ = statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, errorReason, "%s: %v", resolveSecretsFailure, err)
+		return ctrl.Result{}, err
+	}
+
+	breaker := provisioners.CircuitBreakerFor("CfsslIssuer", req.NamespacedName, cfssl.Spec.CircuitBreaker)
+	statusReconciler.setCondition(circuitCondition(breaker.State()))
+	opts = append(opts, issuerOptions("CfsslIssuer", req.NamespacedName, cfssl.Spec)...)
+
+	// Reuse the cached provisioner unless the issuer or the Secrets it
+	// references changed, keeping connections and a discovered CA chain
+	p, cached := r.Provisioners.Get(cfssl, opts...)
+	if !cached {
+		if p, err = r.Provisioners.Build(cfssl.Spec, opts...); err != nil {
+			log.Error(err, initProvisionerFailure)
+			_ = statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, errorReason, initProvisionerFailure)
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := p.Probe(ctx); err != nil {
+		log.Error(err, healthCheckFailure)
+		cfssl.Status.Endpoints = p.EndpointStatus()
+		if !cached {
+			r.Provisioners.Discard(p)
+		}
+		if updateErr := statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, probeFailureReason(err),
+			"%s: %v", healthCheckFailure, err); updateErr != nil || r.HealthCheckInterval == 0 {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: r.HealthCheckInterval}, nil
+	}
+
+	// Only cache provisioners which passed the health check, as they are
+	// used to sign certificates
+	if !cached {
+		p = r.Provisioners.Store("CfsslIssuer", cfssl, p)
+	}
+
+	if err := p.RefreshCAChain(ctx); err != nil {
+		log.Error(err, caDiscoveryFailure)
+		cfssl.Status.Endpoints = p.EndpointStatus()
+		_ = statusReconciler.Update(ctx, certmanagerv1beta1.ConditionFalse, errorReason, "%s: %v", caDiscoveryFailure, err)
 		return ctrl.Result{}, err
 	}
 
-	provisioners.Store(req.NamespacedName, p)
+	cfssl.Status.Endpoints = p.EndpointStatus()
+	cfssl.Status.CA = p.CAStatus()
 
-	return ctrl.Result{},
-		statusReconciler.Update(ctx, certmanagerv1beta1.ConditionTrue, "Verified", "CfsslIssuer verified and ready to sign certificates")
+	// Requeue to check the health, refresh a discovered CA chain and show
+	// the breaker half-opening
+	requeue := requeueAfter(r.HealthCheckInterval, p.CAChainRefreshInterval(), breaker.RetryAfter())
+	return ctrl.Result{RequeueAfter: requeue}, statusReconciler.Update(
+		ctx, certmanagerv1beta1.ConditionTrue, "Verified", "CfsslIssuer verified and ready to sign certificates")
 }
 
 // SetupWithManager registers CfsslIssuerReconciler with the given manager
 func (r *CfsslIssuerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := indexReferences(context.Background(), mgr, &certmanagerv1beta1.CfsslIssuer{},
+		func(obj client.Object) certmanagerv1beta1.CfsslIssuerSpec {
+			return obj.(*certmanagerv1beta1.CfsslIssuer).Spec
+		}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&certmanagerv1beta1.CfsslIssuer{}).
+		Watches(&source.Kind{Type: &core.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.issuersFor(secretRefsField)),
+			builder.OnlyMetadata).
+		Watches(&source.Kind{Type: &core.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.issuersFor(configMapRefsField)),
+			builder.OnlyMetadata).
+		Watches(circuitChanges("CfsslIssuer"), &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
 
+// issuersFor returns a handler.MapFunc mapping a Secret or ConfigMap to the
+// CfsslIssuers referencing it by the given index field, so that their
+// provisioners are rebuilt when the object changes.
+func (r *CfsslIssuerReconciler) issuersFor(field string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		issuers := &certmanagerv1beta1.CfsslIssuerList{}
+		if err := r.List(context.Background(), issuers, client.InNamespace(obj.GetNamespace()),
+			client.MatchingFields{field: obj.GetName()}); err != nil {
+			r.Log.Error(err, "failed to list CfsslIssuers", "namespace", obj.GetNamespace())
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range issuers.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: issuers.Items[i].Namespace,
+					Name:      issuers.Items[i].Name,
+				},
+			})
+		}
+
+		return requests
+	}
+}
+
 func validateCfsslIssuerSpec(c certmanagerv1beta1.CfsslIssuerSpec) error {
+	if c.Mode == certmanagerv1beta1.LocalMode {
+		return validateLocalSpec(c)
+	}
+
 	switch {
-	case c.URL == "":
-		return fmt.Errorf("spec.url cannot be empty")
-	case len(c.CABundle) == 0:
-		return fmt.Errorf("spec.caBundle cannot be empty")
-	default:
-		return nil
+	case c.URL == "" && len(c.URLs) == 0:
+		return fmt.Errorf("spec.url or spec.urls must be set")
+	case containsString(c.URLs, ""):
+		return fmt.Errorf("spec.urls cannot contain empty urls")
+	case len(c.CABundle) == 0 && c.CABundleRef == nil:
+		return fmt.Errorf("spec.caBundle or spec.caBundleRef must be set")
+	case len(c.CABundle) != 0 && c.CABundleRef != nil:
+		return fmt.Errorf("only one of spec.caBundle and spec.caBundleRef may be set")
 	}
+
+	if len(c.CABundle) > 0 {
+		if _, err := pki.DecodeX509CertificateChainBytes(c.CABundle); err != nil {
+			return fmt.Errorf("spec.caBundle must be PEM encoded certificates: %v", err)
+		}
+	}
+
+	if len(c.CAChain) > 0 && c.DiscoverCAChain {
+		return fmt.Errorf("only one of spec.caChain and spec.discoverCAChain may be set")
+	}
+
+	return validateCommonSpec(c)
+}
+
+// validateLocalSpec validates the spec of an issuer in local mode.
+func validateLocalSpec(c certmanagerv1beta1.CfsslIssuerSpec) error {
+	switch {
+	case c.Local == nil || c.Local.CASecretRef.Name == "":
+		return fmt.Errorf("spec.local.caSecretRef must be set in local mode")
+	case c.DiscoverCAChain:
+		return fmt.Errorf("spec.discoverCAChain cannot be set in local mode")
+	}
+
+	return validateCommonSpec(c)
+}
+
+// validateCommonSpec validates the settings shared by the signing modes.
+func validateCommonSpec(c certmanagerv1beta1.CfsslIssuerSpec) error {
+	if len(c.CAChain) > 0 {
+		if _, err := pki.DecodeX509CertificateChainBytes(c.CAChain); err != nil {
+			return fmt.Errorf("spec.caChain must be a PEM encoded certificate chain: %v", err)
+		}
+	}
+
+	if c.SignTimeout != nil && c.SignTimeout.Duration <= 0 {
+		return fmt.Errorf("spec.signTimeout must be positive")
+	}
+
+	if c.MaxDuration != nil && c.MaxDuration.Duration <= 0 {
+		return fmt.Errorf("spec.maxDuration must be positive")
+	}
+
+	for i, rule := range c.ProfileRules {
+		if rule.Profile == "" {
+			return fmt.Errorf("spec.profileRules[%d].profile must be set", i)
+		}
+	}
+
+	if c.RetryPolicy != nil {
+		if r := newRetrier(c.RetryPolicy); r.initialBackoff <= 0 || r.maxBackoff < r.initialBackoff {
+			return fmt.Errorf("spec.retryPolicy.initialBackoff must be positive and not exceed spec.retryPolicy.maxBackoff")
+		}
+	}
+
+	return nil
 }
 
 // Helper functions to check and remove string from a slice of strings.
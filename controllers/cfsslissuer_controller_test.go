@@ -2,14 +2,28 @@ package controllers
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
+	cmutil "github.com/cert-manager/cert-manager/pkg/api/util"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var caBundle = readAndEncode("testdata/ca.pem")
@@ -31,8 +45,8 @@ var _ = Describe("CfsslIssuer Controller", func() {
 				Namespace: key.Namespace,
 			},
 			Spec: cfsslv1beta1.CfsslIssuerSpec{
-				URL:      "http://test",
-				CABundle: caBundle,
+				URL:      mockCfsslServer.URL,
+				CABundle: encodeCert(mockCfsslServer.Certificate()),
 			},
 		}
 		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
@@ -45,7 +59,7 @@ var _ = Describe("CfsslIssuer Controller", func() {
 		}, timeout, interval).Should(BeTrue())
 
 		By("Updating the scope")
-		fetched.Spec.URL = "http://test.new.url"
+		fetched.Spec.Profile = "new-profile"
 
 		Expect(k8sClient.Update(context.Background(), fetched)).Should(Succeed())
 		time.Sleep(time.Second * 2)
@@ -80,11 +94,29 @@ var _ = Describe("CfsslIssuer Controller", func() {
 			},
 		}
 
-		Expect(k8sClient.Create(context.Background(), missingBundle)).ShouldNot(Succeed())
+		Expect(k8sClient.Create(context.Background(), missingBundle)).Should(Succeed())
 		defer func() {
 			_ = k8sClient.Delete(context.Background(), missingBundle)
 		}()
 
+		Eventually(func() bool {
+			f := &cfsslv1beta1.CfsslIssuer{}
+			err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(missingBundle), f)
+			if err != nil || f == nil {
+				return false
+			}
+
+			for _, cond := range f.Status.Conditions {
+				if cond.Type == cfsslv1beta1.ConditionReady &&
+					cond.Status == cfsslv1beta1.ConditionFalse &&
+					cond.Reason == errorValidation {
+					return true
+				}
+			}
+
+			return false
+		}).Should(BeTrue())
+
 		By("Requiring validCABundle")
 		invalidBundleKey := types.NamespacedName{
 			Name:      "cfssl-issuer-invalid-bundle",
@@ -117,8 +149,8 @@ var _ = Describe("CfsslIssuer Controller", func() {
 				}
 
 				if cond.Status == cfsslv1beta1.ConditionFalse &&
-					cond.Reason == errorReason &&
-					cond.Message == initProvisionerFailure {
+					cond.Reason == errorValidation &&
+					strings.Contains(cond.Message, "spec.caBundle") {
 					return true
 				}
 			}
@@ -129,6 +161,84 @@ var _ = Describe("CfsslIssuer Controller", func() {
 			_ = k8sClient.Delete(context.Background(), invalidBundle)
 		}()
 
+		By("Requiring CABundle to hold certificates")
+		corruptBundleKey := types.NamespacedName{
+			Name:      "cfssl-issuer-corrupt-bundle",
+			Namespace: namespace,
+		}
+		corruptBundle := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      corruptBundleKey.Name,
+				Namespace: namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:      "http://test",
+				CABundle: []byte("-----BEGIN CERTIFICATE-----\nbm90LWEtY2VydGlmaWNhdGU=\n-----END CERTIFICATE-----\n"),
+			},
+		}
+
+		Expect(k8sClient.Create(context.Background(), corruptBundle)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), corruptBundle)
+		}()
+
+		Eventually(func() bool {
+			f := &cfsslv1beta1.CfsslIssuer{}
+			if err := k8sClient.Get(context.Background(), corruptBundleKey, f); err != nil {
+				return false
+			}
+
+			for _, cond := range f.Status.Conditions {
+				if cond.Type == cfsslv1beta1.ConditionReady &&
+					cond.Status == cfsslv1beta1.ConditionFalse &&
+					cond.Reason == errorValidation &&
+					strings.Contains(cond.Message, "spec.caBundle") {
+					return true
+				}
+			}
+
+			return false
+		}).Should(BeTrue())
+
+		By("Requiring a valid CAChain")
+		invalidChainKey := types.NamespacedName{
+			Name:      "cfssl-issuer-invalid-chain",
+			Namespace: namespace,
+		}
+		invalidChain := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      invalidChainKey.Name,
+				Namespace: namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:      "http://test",
+				CABundle: caBundle,
+				CAChain:  []byte("this-isnt-a-chain"),
+			},
+		}
+
+		Expect(k8sClient.Create(context.Background(), invalidChain)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), invalidChain)
+		}()
+
+		Eventually(func() bool {
+			f := &cfsslv1beta1.CfsslIssuer{}
+			if err := k8sClient.Get(context.Background(), invalidChainKey, f); err != nil {
+				return false
+			}
+
+			for _, cond := range f.Status.Conditions {
+				if cond.Type == cfsslv1beta1.ConditionReady &&
+					cond.Status == cfsslv1beta1.ConditionFalse &&
+					cond.Reason == errorValidation {
+					return true
+				}
+			}
+
+			return false
+		}).Should(BeTrue())
+
 		By("Requiring URL")
 		missingURLKey := types.NamespacedName{
 			Name:      "cfssl-issuer-missing-url",
@@ -170,4 +280,302 @@ var _ = Describe("CfsslIssuer Controller", func() {
 			_ = k8sClient.Delete(context.Background(), missingURL)
 		}()
 	})
+
+	It("Should resolve the auth key from a Secret", func() {
+		key := types.NamespacedName{
+			Name:      "cfssl-issuer-auth",
+			Namespace: namespace,
+		}
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL:      mockCfsslServer.URL,
+				CABundle: encodeCert(mockCfsslServer.Certificate()),
+				AuthKeySecretRef: &cfsslv1beta1.SecretKeySelector{
+					Name: "cfssl-auth",
+					Key:  "key",
+				},
+			},
+		}
+
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		By("Failing while the Secret is missing")
+		Eventually(func() bool {
+			f := &cfsslv1beta1.CfsslIssuer{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			for _, cond := range f.Status.Conditions {
+				if cond.Type == cfsslv1beta1.ConditionReady &&
+					cond.Status == cfsslv1beta1.ConditionFalse &&
+					cond.Reason == errorReason {
+					return true
+				}
+			}
+
+			return false
+		}, timeout, interval).Should(BeTrue())
+
+		By("Becoming ready once the Secret exists")
+		secret := &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cfssl-auth",
+				Namespace: namespace,
+			},
+			Data: map[string][]byte{
+				"key": []byte(mock.AuthKey),
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), secret)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), secret)
+		}()
+
+		Eventually(func() bool {
+			f := &cfsslv1beta1.CfsslIssuer{}
+			_ = k8sClient.Get(context.Background(), key, f)
+			return f.IsReady()
+		}, time.Second*30, interval).Should(BeTrue())
+	})
+
+	It("Should sign with a local CA", func() {
+		certPEM, keyPEM := newCAKeyPair()
+		secret := &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cfssl-local-ca",
+				Namespace: namespace,
+			},
+			Type: core.SecretTypeTLS,
+			Data: map[string][]byte{
+				core.TLSCertKey:       certPEM,
+				core.TLSPrivateKeyKey: keyPEM,
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), secret)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), secret)
+		}()
+
+		key := types.NamespacedName{
+			Name:      "cfssl-issuer-local",
+			Namespace: namespace,
+		}
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				Mode: cfsslv1beta1.LocalMode,
+				Local: &cfsslv1beta1.LocalSigner{
+					CASecretRef: cfsslv1beta1.LocalObjectReference{Name: secret.Name},
+					Config: `{"signing": {
+						"default": {"expiry": "1h", "usages": ["signing", "client auth"]},
+						"profiles": {"server": {"expiry": "1h", "usages": ["signing", "server auth"]}}
+					}}`,
+				},
+				ProfileRules: []cfsslv1beta1.ProfileRule{
+					{Profile: "server", Usages: []string{string(cmapi.UsageServerAuth)}},
+				},
+				MaxDuration:  &metav1.Duration{Duration: 30 * time.Minute},
+				Verification: cfsslv1beta1.StrictVerification,
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		By("Becoming ready without a Cfssl Server")
+		Eventually(func() bool {
+			f := &cfsslv1beta1.CfsslIssuer{}
+			_ = k8sClient.Get(context.Background(), key, f)
+			return f.IsReady() && f.Status.CA != nil
+		}, time.Second*30, interval).Should(BeTrue())
+
+		By("Signing certificate requests")
+		csr := createCSR("csr-local", "certmanager.thg.io", "CfsslIssuer", key.Name)
+		csr.Spec.Duration = &metav1.Duration{Duration: 24 * time.Hour}
+		csr.Spec.Usages = []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageServerAuth}
+		csrKey := client.ObjectKeyFromObject(csr)
+		Expect(k8sClient.Create(context.Background(), csr)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), csr)
+		}()
+		setApprovalCondition(csrKey, cmapi.CertificateRequestConditionApproved)
+
+		Eventually(func() []byte {
+			f := &cmapi.CertificateRequest{}
+			_ = k8sClient.Get(context.Background(), csrKey, f)
+			return f.Status.CA
+		}, time.Second*30, interval).Should(Equal(certPEM))
+
+		By("Capping the requested duration to the maximum of the issuer")
+		f := &cmapi.CertificateRequest{}
+		Expect(k8sClient.Get(context.Background(), csrKey, f)).Should(Succeed())
+		leaf, err := pki.DecodeX509CertificateBytes(f.Status.Certificate)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(leaf.NotAfter.Sub(leaf.NotBefore)).Should(Equal(30 * time.Minute))
+
+		By("Selecting the signing profile by the profile rules")
+		Expect(leaf.ExtKeyUsage).Should(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}))
+		cond := cmutil.GetCertificateRequestCondition(f, cmapi.CertificateRequestConditionReady)
+		Expect(cond.Message).Should(HaveSuffix("with profile server"))
+	})
+
+	It("Should reload the CA bundle from a ConfigMap", func() {
+		key := types.NamespacedName{
+			Name:      "cfssl-issuer-bundle-ref",
+			Namespace: namespace,
+		}
+		issuer := &cfsslv1beta1.CfsslIssuer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+			},
+			Spec: cfsslv1beta1.CfsslIssuerSpec{
+				URL: mockCfsslServer.URL,
+				CABundleRef: &cfsslv1beta1.CABundleReference{
+					Kind: "ConfigMap",
+					Name: "cfssl-ca",
+					Key:  "ca.crt",
+				},
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), issuer)
+		}()
+
+		cm := &core.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cfssl-ca",
+				Namespace: namespace,
+			},
+			Data: map[string]string{
+				"ca.crt": string(encodeCert(mockCfsslServer.Certificate())),
+			},
+		}
+		Expect(k8sClient.Create(context.Background(), cm)).Should(Succeed())
+		defer func() {
+			_ = k8sClient.Delete(context.Background(), cm)
+		}()
+
+		Eventually(func() bool {
+			f := &cfsslv1beta1.CfsslIssuer{}
+			_ = k8sClient.Get(context.Background(), key, f)
+			return f.IsReady()
+		}, time.Second*30, interval).Should(BeTrue())
+
+		By("Rebuilding the provisioner when the ConfigMap changes")
+		cm.Data["ca.crt"] = "this-isnt-a-bundle"
+		Expect(k8sClient.Update(context.Background(), cm)).Should(Succeed())
+
+		Eventually(func() bool {
+			f := &cfsslv1beta1.CfsslIssuer{}
+			if err := k8sClient.Get(context.Background(), key, f); err != nil {
+				return false
+			}
+
+			for _, cond := range f.Status.Conditions {
+				if cond.Type == cfsslv1beta1.ConditionReady &&
+					cond.Status == cfsslv1beta1.ConditionFalse &&
+					cond.Message == initProvisionerFailure {
+					return true
+				}
+			}
+
+			return false
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should report an unhealthy cfssl server", func() {
+		tests := []struct {
+			name   string
+			spec   cfsslv1beta1.CfsslIssuerSpec
+			reason string
+		}{
+			{
+				name: "cfssl-issuer-unreachable",
+				spec: cfsslv1beta1.CfsslIssuerSpec{
+					URL:      "https://127.0.0.1:1",
+					CABundle: caBundle,
+				},
+				reason: unreachableReason,
+			},
+			{
+				name: "cfssl-issuer-misconfigured",
+				spec: cfsslv1beta1.CfsslIssuerSpec{
+					URL:      mockCfsslServer.URL,
+					CABundle: encodeCert(mockCfsslServer.Certificate()),
+					Profile:  mock.UnknownProfile,
+				},
+				reason: misconfiguredReason,
+			},
+		}
+
+		for _, tc := range tests {
+			issuer := &cfsslv1beta1.CfsslIssuer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      tc.name,
+					Namespace: namespace,
+				},
+				Spec: tc.spec,
+			}
+			Expect(k8sClient.Create(context.Background(), issuer)).Should(Succeed())
+			defer func() {
+				_ = k8sClient.Delete(context.Background(), issuer)
+			}()
+
+			Eventually(func() bool {
+				f := &cfsslv1beta1.CfsslIssuer{}
+				if err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(issuer), f); err != nil {
+					return false
+				}
+
+				for _, cond := range f.Status.Conditions {
+					if cond.Type == cfsslv1beta1.ConditionReady &&
+						cond.Status == cfsslv1beta1.ConditionFalse &&
+						cond.Reason == tc.reason {
+						return true
+					}
+				}
+
+				return false
+			}, timeout, interval).Should(BeTrue())
+		}
+	})
 })
+
+// newCAKeyPair returns the PEM encoded certificate and key of a self signed
+// CA, valid for an hour.
+func newCAKeyPair() ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "cfssl-issuer local CA"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
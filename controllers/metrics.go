@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"errors"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners"
+)
+
+const (
+	metricsNamespace = "cfssl_issuer"
+
+	resultIssued   = "issued"
+	resultFailed   = "failed"
+	resultRetrying = "retrying"
+	resultDenied   = "denied"
+)
+
+var (
+	certificateRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "CertificateRequests handled by issuers, by result and error class",
+		Namespace: metricsNamespace,
+		Name:      "certificate_requests_total",
+	}, []string{"kind", "namespace", "name", "result", "class"})
+	issuerReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Help:      "whether issuers are ready, 1 ready, 0 not ready",
+		Namespace: metricsNamespace,
+		Name:      "issuer_ready",
+	}, []string{"kind", "namespace", "name"})
+	caExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Help:      "expiry of the issuing CA of issuers as unix timestamp",
+		Namespace: metricsNamespace,
+		Name:      "ca_expiry_timestamp_seconds",
+	}, []string{"kind", "namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(certificateRequests)
+	metrics.Registry.MustRegister(issuerReady)
+	metrics.Registry.MustRegister(caExpiry)
+}
+
+// recordResult counts a CertificateRequest with the given result. Failed
+// and retried requests are labelled with the class of the signing error.
+func recordResult(cr *cmapi.CertificateRequest, result string, err error) {
+	namespace := ""
+	if cr.Spec.IssuerRef.Kind == "CfsslIssuer" {
+		namespace = cr.Namespace
+	}
+
+	class := ""
+	var serr *provisioners.SignError
+	if errors.As(err, &serr) {
+		class = string(serr.Class)
+	} else if err != nil {
+		class = string(provisioners.Unknown)
+	}
+
+	certificateRequests.WithLabelValues(cr.Spec.IssuerRef.Kind, namespace, cr.Spec.IssuerRef.Name, result, class).Inc()
+}
+
+// recordIssuerStatus updates the readiness and CA expiry gauges of the
+// issuer from its status.
+func recordIssuerStatus(kind string, key types.NamespacedName, status cfsslv1beta1.CfsslIssuerStatus) {
+	ready := 0.0
+	for _, cond := range status.Conditions {
+		if cond.Type == cfsslv1beta1.ConditionReady && cond.Status == cfsslv1beta1.ConditionTrue {
+			ready = 1
+		}
+	}
+	issuerReady.WithLabelValues(kind, key.Namespace, key.Name).Set(ready)
+
+	if status.CA == nil {
+		caExpiry.DeleteLabelValues(kind, key.Namespace, key.Name)
+		return
+	}
+	caExpiry.WithLabelValues(kind, key.Namespace, key.Name).Set(float64(status.CA.NotAfter.Unix()))
+}
+
+// removeIssuerMetrics removes the gauges of a deleted issuer.
+func removeIssuerMetrics(kind string, key types.NamespacedName) {
+	issuerReady.DeleteLabelValues(kind, key.Namespace, key.Name)
+	caExpiry.DeleteLabelValues(kind, key.Namespace, key.Name)
+}
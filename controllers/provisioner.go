@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners"
+)
+
+// UncachedObjects are the types the manager's client must read from the API
+// server rather than its cache. The issuer controllers only watch the
+// metadata of Secrets and ConfigMaps, so that the contents of every Secret
+// in the cluster are not held in memory, and read the few they reference
+// directly.
+var UncachedObjects = []client.Object{&core.Secret{}, &core.ConfigMap{}}
+
+const (
+	// secretRefsField and configMapRefsField index issuers by the names of
+	// the Secrets and ConfigMaps they reference.
+	secretRefsField    = ".spec.secretRefs"
+	configMapRefsField = ".spec.configMapRefs"
+
+	resolveSecretsFailure = "failed to resolve referenced secrets"
+	healthCheckFailure    = "cfssl health check failed"
+
+	unreachableReason   = "Unreachable"
+	misconfiguredReason = "Misconfigured"
+
+	// signTimeoutReason is the Ready reason of CertificateRequests whose
+	// signing request timed out. Like Pending, it is retried.
+	signTimeoutReason = "Timeout"
+
+	secretKind    = "Secret"
+	configMapKind = "ConfigMap"
+)
+
+// provisionerOptions resolves the Secrets referenced by the given spec from
+// namespace into options for provisioners.NewBackend.
+func provisionerOptions(ctx context.Context,
+	c client.Reader,
+	namespace string,
+	spec cfsslv1beta1.CfsslIssuerSpec,
+) ([]provisioners.Option, error) {
+	var opts []provisioners.Option
+
+	if spec.Mode == cfsslv1beta1.LocalMode {
+		cert, key, err := keyPairData(ctx, c, namespace, spec.Local.CASecretRef.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA key pair: %w", err)
+		}
+		return append(opts, provisioners.WithCAKeyPair(cert, key)), nil
+	}
+
+	if spec.AuthKeySecretRef != nil {
+		key, err := secretKeyData(ctx, c, namespace, spec.AuthKeySecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load auth key: %w", err)
+		}
+		opts = append(opts, provisioners.WithAuthKey(string(key)))
+	}
+
+	if spec.CABundleRef != nil {
+		bundle, err := caBundleData(ctx, c, namespace, spec.CABundleRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA bundle: %w", err)
+		}
+		opts = append(opts, provisioners.WithCABundle(bundle))
+	}
+
+	if spec.ClientCertSecretRef != nil {
+		cert, err := clientCertificate(ctx, c, namespace, spec.ClientCertSecretRef.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		opts = append(opts, provisioners.WithClientCertificate(cert))
+	}
+
+	return opts, nil
+}
+
+// issuerOptions returns the options sharing the metrics labels, circuit
+// breaker and rate limiter of the issuer of the given kind with its
+// provisioner.
+func issuerOptions(kind string, key types.NamespacedName, spec cfsslv1beta1.CfsslIssuerSpec) []provisioners.Option {
+	return []provisioners.Option{
+		provisioners.WithIssuer(kind, key),
+		provisioners.WithCircuitBreaker(provisioners.CircuitBreakerFor(kind, key, spec.CircuitBreaker)),
+		provisioners.WithLimiter(provisioners.LimiterFor(kind, key, spec.RateLimit)),
+	}
+}
+
+// forgetIssuer releases the provisioner, circuit breaker, rate limiter,
+// endpoint health and metrics of the deleted issuer of the given kind.
+func forgetIssuer(cache *provisioners.Cache, kind string, key types.NamespacedName) {
+	cache.Remove(kind, key)
+	provisioners.RemoveCircuitBreaker(key)
+	provisioners.RemoveLimiter(key)
+	provisioners.RemoveEndpointHealth(key)
+	removeIssuerMetrics(kind, key)
+}
+
+// probeFailureReason returns the condition reason for an error returned by
+// provisioners.Backend.Probe.
+func probeFailureReason(err error) string {
+	var probeErr *provisioners.ProbeError
+	if errors.As(err, &probeErr) && probeErr.Unreachable {
+		return unreachableReason
+	}
+	return misconfiguredReason
+}
+
+// circuitCondition returns the CircuitOpen condition for the state of an
+// issuer's circuit breaker.
+func circuitCondition(state provisioners.CircuitState) (
+	cfsslv1beta1.ConditionType, cfsslv1beta1.ConditionStatus, string, string,
+) {
+	switch state {
+	case provisioners.CircuitOpen:
+		return cfsslv1beta1.ConditionCircuitOpen, cfsslv1beta1.ConditionTrue, state.String(),
+			"Signing requests are not sent to the Cfssl Server after repeated failures"
+	case provisioners.CircuitHalfOpen:
+		return cfsslv1beta1.ConditionCircuitOpen, cfsslv1beta1.ConditionTrue, state.String(),
+			"Trial signing requests are sent to the Cfssl Server"
+	default:
+		return cfsslv1beta1.ConditionCircuitOpen, cfsslv1beta1.ConditionFalse, state.String(),
+			"Signing requests are sent to the Cfssl Server"
+	}
+}
+
+// circuitChangesBufferSize is the number of circuit breaker changes buffered
+// for a controller before further changes are dropped.
+const circuitChangesBufferSize = 64
+
+// circuitSource is a source of the issuers of a kind whose circuit breaker
+// changed state.
+type circuitSource struct {
+	*source.Channel
+	kind    string
+	changes chan event.GenericEvent
+}
+
+// circuitChanges returns a source of the issuers of the given kind whose
+// circuit breaker changed state, so that their CircuitOpen condition is
+// updated right away rather than on the next health check.
+func circuitChanges(kind string) source.Source {
+	changes := make(chan event.GenericEvent, circuitChangesBufferSize)
+	return &circuitSource{Channel: &source.Channel{Source: changes}, kind: kind, changes: changes}
+}
+
+// Start observes the circuit breakers until ctx is done. Changes are dropped
+// rather than blocking the breaker when the controller falls behind, as the
+// next health check updates the CircuitOpen condition anyway.
+func (s *circuitSource) Start(ctx context.Context, h handler.EventHandler, q workqueue.RateLimitingInterface,
+	prct ...predicate.Predicate) error {
+	remove := provisioners.OnCircuitStateChange(func(kind string, key types.NamespacedName, _ provisioners.CircuitState) {
+		if kind != s.kind {
+			return
+		}
+		select {
+		case s.changes <- event.GenericEvent{Object: &metav1.PartialObjectMetadata{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+		}}:
+		default:
+		}
+	})
+	if err := s.Channel.Start(ctx, h, q, prct...); err != nil {
+		remove()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		remove()
+	}()
+	return nil
+}
+
+// requeueAfter returns the shortest of the given non-zero intervals, or zero
+// if all of them are zero.
+func requeueAfter(intervals ...time.Duration) time.Duration {
+	var shortest time.Duration
+	for _, interval := range intervals {
+		if interval > 0 && (shortest == 0 || interval < shortest) {
+			shortest = interval
+		}
+	}
+	return shortest
+}
+
+// referencedSecrets returns the names of the Secrets referenced by the given spec.
+func referencedSecrets(spec cfsslv1beta1.CfsslIssuerSpec) []string {
+	var names []string
+	if spec.AuthKeySecretRef != nil {
+		names = append(names, spec.AuthKeySecretRef.Name)
+	}
+	if spec.ClientCertSecretRef != nil {
+		names = append(names, spec.ClientCertSecretRef.Name)
+	}
+	if spec.CABundleRef != nil && spec.CABundleRef.Kind == secretKind {
+		names = append(names, spec.CABundleRef.Name)
+	}
+	if spec.Local != nil {
+		names = append(names, spec.Local.CASecretRef.Name)
+	}
+	return names
+}
+
+// referencedConfigMaps returns the names of the ConfigMaps referenced by the given spec.
+func referencedConfigMaps(spec cfsslv1beta1.CfsslIssuerSpec) []string {
+	if spec.CABundleRef != nil && spec.CABundleRef.Kind == configMapKind {
+		return []string{spec.CABundleRef.Name}
+	}
+	return nil
+}
+
+// indexReferences indexes the issuers of the given type by the names of the
+// Secrets and ConfigMaps they reference, so that the issuers referencing a
+// changed object are listed from the index rather than by scanning them all.
+func indexReferences(ctx context.Context, mgr ctrl.Manager, obj client.Object,
+	spec func(client.Object) cfsslv1beta1.CfsslIssuerSpec) error {
+	indexer := mgr.GetFieldIndexer()
+	if err := indexer.IndexField(ctx, obj, secretRefsField, func(o client.Object) []string {
+		return referencedSecrets(spec(o))
+	}); err != nil {
+		return err
+	}
+	return indexer.IndexField(ctx, obj, configMapRefsField, func(o client.Object) []string {
+		return referencedConfigMaps(spec(o))
+	})
+}
+
+// caBundleData returns the CA bundle stored in the referenced Secret or ConfigMap.
+func caBundleData(ctx context.Context,
+	c client.Reader,
+	namespace string,
+	ref *cfsslv1beta1.CABundleReference,
+) ([]byte, error) {
+	if ref.Kind == secretKind {
+		return secretKeyData(ctx, c, namespace, &cfsslv1beta1.SecretKeySelector{Name: ref.Name, Key: ref.Key})
+	}
+
+	cm := &core.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, cm); err != nil {
+		return nil, err
+	}
+
+	if data, ok := cm.Data[ref.Key]; ok {
+		return []byte(data), nil
+	}
+	if data, ok := cm.BinaryData[ref.Key]; ok {
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("key %q not found in configmap %s/%s", ref.Key, namespace, ref.Name)
+}
+
+// clientCertificate loads the key pair stored in a kubernetes.io/tls Secret.
+func clientCertificate(ctx context.Context, c client.Reader, namespace, name string) (tls.Certificate, error) {
+	certPEM, keyPEM, err := keyPairData(ctx, c, namespace, name)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// keyPairData returns the PEM encoded certificate and key stored in a
+// kubernetes.io/tls Secret.
+func keyPairData(ctx context.Context, c client.Reader, namespace, name string) ([]byte, []byte, error) {
+	certPEM, err := secretKeyData(ctx, c, namespace, &cfsslv1beta1.SecretKeySelector{Name: name, Key: core.TLSCertKey})
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := secretKeyData(ctx, c, namespace, &cfsslv1beta1.SecretKeySelector{Name: name, Key: core.TLSPrivateKeyKey})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certPEM, keyPEM, nil
+}
+
+// secretKeyData returns the data stored under the referenced key of a Secret.
+func secretKeyData(ctx context.Context,
+	c client.Reader,
+	namespace string,
+	ref *cfsslv1beta1.SecretKeySelector,
+) ([]byte, error) {
+	secret := &core.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, secret); err != nil {
+		return nil, err
+	}
+
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found in secret %s/%s", ref.Key, namespace, ref.Name)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"strconv"
+	"time"
+
+	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// signAttemptsAnnotation records the number of failed signing attempts
+	// of a CertificateRequest.
+	signAttemptsAnnotation = "certmanager.thg.io/sign-attempts"
+	// nextRetryAnnotation records when signing a CertificateRequest is
+	// retried next, in RFC 3339 format.
+	nextRetryAnnotation = "certmanager.thg.io/next-sign-retry"
+	// issuerRevisionAnnotation records the revision of the issuer, see
+	// issuerRevision, at the last failed signing attempt.
+	issuerRevisionAnnotation = "certmanager.thg.io/sign-issuer-revision"
+
+	defaultInitialBackoff = 5 * time.Second
+	defaultMaxBackoff     = 5 * time.Minute
+)
+
+// retryState is the retry progress of a CertificateRequest, stored in its
+// annotations so that it survives controller restarts.
+type retryState struct {
+	attempts       int
+	nextRetry      time.Time
+	issuerRevision string
+}
+
+func retryStateOf(cr *cmapi.CertificateRequest) retryState {
+	var s retryState
+	s.attempts, _ = strconv.Atoi(cr.Annotations[signAttemptsAnnotation])
+	s.nextRetry, _ = time.Parse(time.RFC3339, cr.Annotations[nextRetryAnnotation])
+	s.issuerRevision = cr.Annotations[issuerRevisionAnnotation]
+	return s
+}
+
+func (s retryState) apply(cr *cmapi.CertificateRequest) {
+	if cr.Annotations == nil {
+		cr.Annotations = make(map[string]string)
+	}
+	cr.Annotations[signAttemptsAnnotation] = strconv.Itoa(s.attempts)
+	if s.nextRetry.IsZero() {
+		delete(cr.Annotations, nextRetryAnnotation)
+	} else {
+		cr.Annotations[nextRetryAnnotation] = s.nextRetry.UTC().Format(time.RFC3339)
+	}
+	if s.issuerRevision == "" {
+		delete(cr.Annotations, issuerRevisionAnnotation)
+	} else {
+		cr.Annotations[issuerRevisionAnnotation] = s.issuerRevision
+	}
+}
+
+// clearRetryState removes the retry state from the annotations of the
+// CertificateRequest, returning whether it had any.
+func clearRetryState(cr *cmapi.CertificateRequest) bool {
+	cleared := false
+	for _, annotation := range []string{signAttemptsAnnotation, nextRetryAnnotation, issuerRevisionAnnotation} {
+		if _, ok := cr.Annotations[annotation]; ok {
+			delete(cr.Annotations, annotation)
+			cleared = true
+		}
+	}
+	return cleared
+}
+
+// issuerRevision identifies the generation of the issuer and the last time
+// it became Ready or not Ready. CertificateRequests waiting to retry are
+// retried right away once it changes.
+func issuerRevision(issuer client.Object) string {
+	var conditions []cfsslv1beta1.CfsslIssuerCondition
+	switch issuer := issuer.(type) {
+	case *cfsslv1beta1.CfsslIssuer:
+		conditions = issuer.Status.Conditions
+	case *cfsslv1beta1.CfsslClusterIssuer:
+		conditions = issuer.Status.Conditions
+	}
+
+	revision := strconv.FormatInt(issuer.GetGeneration(), 10)
+	for _, cond := range conditions {
+		if cond.Type == cfsslv1beta1.ConditionReady && cond.LastTransitionTime != nil {
+			revision += "/" + cond.LastTransitionTime.UTC().Format(time.RFC3339)
+		}
+	}
+	return revision
+}
+
+// retrier decides when a failed signing attempt is retried.
+type retrier struct {
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxAttempts    int
+	deadline       time.Duration
+}
+
+func newRetrier(policy *cfsslv1beta1.RetryPolicy) retrier {
+	r := retrier{
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+	}
+	if policy == nil {
+		return r
+	}
+
+	if policy.InitialBackoff != nil {
+		r.initialBackoff = policy.InitialBackoff.Duration
+	}
+	if policy.MaxBackoff != nil {
+		r.maxBackoff = policy.MaxBackoff.Duration
+	}
+	r.maxAttempts = int(policy.MaxAttempts)
+	if policy.Deadline != nil {
+		r.deadline = policy.Deadline.Duration
+	}
+
+	return r
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (r retrier) backoff(attempts int) time.Duration {
+	delay := r.initialBackoff
+	for i := 1; i < attempts && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	return delay
+}
+
+// giveUp returns whether a CertificateRequest created at the given time is
+// no longer retried after the given number of failed attempts.
+func (r retrier) giveUp(created, now time.Time, attempts int) bool {
+	if r.maxAttempts > 0 && attempts >= r.maxAttempts {
+		return true
+	}
+	return r.deadline > 0 && !now.Before(created.Add(r.deadline))
+}
@@ -24,6 +24,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	cfsslv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
 	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
@@ -39,6 +40,7 @@ import (
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners"
 	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
 	// +kubebuilder:scaffold:imports
 )
@@ -93,18 +95,31 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 
 	k8sManager, err = ctrl.NewManager(cfg, ctrl.Options{
-		Scheme: scheme.Scheme,
+		Scheme:                scheme.Scheme,
+		ClientDisableCacheFor: UncachedObjects,
 	})
 	Expect(err).NotTo(HaveOccurred())
 
 	k8sClient, err = client.New(cfg, client.Options{})
 	Expect(err).NotTo(HaveOccurred())
 
+	cache := provisioners.NewCache()
+
 	err = (&CertificateRequestReconciler{
 		Client:   k8sManager.GetClient(),
 		Log:      ctrl.Log.WithName("controllers").WithName("CertificateRequest"),
 		Clock:    clock.RealClock{},
 		Recorder: k8sManager.GetEventRecorderFor("certificaterequests-controller"),
+
+		Provisioners:             cache,
+		ClusterResourceNamespace: namespace,
+	}).SetupWithManager(k8sManager)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&CertificateRequestApproverReconciler{
+		Client:   k8sManager.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("CertificateRequestApprover"),
+		Recorder: k8sManager.GetEventRecorderFor("certificaterequests-approver"),
 	}).SetupWithManager(k8sManager)
 	Expect(err).NotTo(HaveOccurred())
 
@@ -113,6 +128,9 @@ var _ = BeforeSuite(func() {
 		Log:      ctrl.Log.WithName("controllers").WithName("CfsslIssuer"),
 		Clock:    clock.RealClock{},
 		Recorder: k8sManager.GetEventRecorderFor("cfsslissuer-controller"),
+
+		HealthCheckInterval: time.Second,
+		Provisioners:        cache,
 	}).SetupWithManager(k8sManager)
 	Expect(err).NotTo(HaveOccurred())
 
@@ -121,6 +139,10 @@ var _ = BeforeSuite(func() {
 		Log:      ctrl.Log.WithName("controllers").WithName("CfsslClusterIssuer"),
 		Clock:    clock.RealClock{},
 		Recorder: k8sManager.GetEventRecorderFor("cfsslclusterissuer-controller"),
+
+		ClusterResourceNamespace: namespace,
+		HealthCheckInterval:      time.Second,
+		Provisioners:             cache,
 	}).SetupWithManager(k8sManager)
 	Expect(err).NotTo(HaveOccurred())
 
@@ -16,13 +16,18 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"time"
 
 	"k8s.io/utils/clock"
 
 	certmanagerv1beta1 "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/OpenSource-THG/cfssl-issuer/audit"
 	"github.com/OpenSource-THG/cfssl-issuer/controllers"
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners"
+	"github.com/OpenSource-THG/cfssl-issuer/tracing"
 
 	certmanager "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -49,37 +54,126 @@ func init() {
 }
 
 func main() {
+	if err := run(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// run runs the controller manager until it is stopped, returning the error
+// it failed with after logging it. Deferred calls, e.g. flushing traces,
+// run before main exits.
+func run() error {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var clusterResourceNamespace string
+	var healthCheckInterval time.Duration
+	var disableApprovalCheck bool
+	var enableApprover bool
+	var maxConcurrentReconciles int
+	var otlpEndpoint string
+	var otlpInsecure bool
+	var auditLog string
+	var auditWebhookURL string
+	var auditWebhookAttempts int
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&clusterResourceNamespace, "cluster-resource-namespace", "cfssl-issuer-system",
+		"The namespace Secrets referenced by CfsslClusterIssuers are read from.")
+	flag.DurationVar(&healthCheckInterval, "health-check-interval", time.Minute,
+		"The interval at which the cfssl servers of ready issuers are probed. Set to 0 to disable periodic health checks.")
+	flag.BoolVar(&disableApprovalCheck, "disable-approval-check", false,
+		"Sign CertificateRequests without waiting for them to be approved. Only intended for clusters without cert-manager approval support.")
+	flag.BoolVar(&enableApprover, "enable-approver", false,
+		"Enable the approver controller, approving or denying CertificateRequests according to CfsslSigningPolicies.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of CertificateRequests reconciled concurrently.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "",
+		"The host:port of the OTLP gRPC collector traces are exported to. Tracing is disabled if empty.")
+	flag.BoolVar(&otlpInsecure, "otlp-insecure", false,
+		"Connect to the OTLP collector without TLS.")
+	flag.StringVar(&auditLog, "audit-log", "",
+		"The file issued certificates are recorded to as JSON lines, or - for stdout. Disabled if empty.")
+	flag.StringVar(&auditWebhookURL, "audit-webhook-url", "",
+		"The url issued certificates are posted to as JSON. Disabled if empty.")
+	flag.IntVar(&auditWebhookAttempts, "audit-webhook-attempts", 5,
+		"The number of attempts to deliver an audit record to the webhook.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
 		o.Development = true
 	}))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	shutdownTracing, err := tracing.Setup(context.Background(), otlpEndpoint, otlpInsecure)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			setupLog.Error(err, "problem flushing traces")
+		}
+	}()
+
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		setupLog.Error(err, "unable to get kubeconfig")
+		return err
+	}
+
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
 		LeaderElection:     enableLeaderElection,
 		LeaderElectionID:   "cfssl-issuer-leader-election-helper",
 		Port:               9443,
+
+		ClientDisableCacheFor: controllers.UncachedObjects,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
-		os.Exit(1)
+		return err
 	}
 
+	var sinks []audit.Sink
+	switch auditLog {
+	case "":
+	case "-":
+		sinks = append(sinks, audit.NewLogSink(os.Stdout))
+	default:
+		f, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			setupLog.Error(err, "unable to open audit log", "path", auditLog)
+			return err
+		}
+		defer f.Close()
+		sinks = append(sinks, audit.NewLogSink(f))
+	}
+	if auditWebhookURL != "" {
+		sinks = append(sinks, audit.NewWebhookSink(auditWebhookURL, audit.WithAttempts(auditWebhookAttempts)))
+	}
+	var auditor *audit.Auditor
+	if len(sinks) > 0 {
+		auditor = audit.NewAuditor(ctrl.Log.WithName("audit"), audit.DefaultQueueSize, sinks...)
+		if err := mgr.Add(auditor); err != nil {
+			setupLog.Error(err, "unable to add auditor")
+			return err
+		}
+	}
+
+	cache := provisioners.NewCache()
+
 	if err = (&controllers.CfsslIssuerReconciler{
 		Client:   mgr.GetClient(),
 		Log:      ctrl.Log.WithName("controllers").WithName("CfsslIssuer"),
 		Clock:    clock.RealClock{},
 		Recorder: mgr.GetEventRecorderFor("cfsslissuer-controller"),
+
+		HealthCheckInterval: healthCheckInterval,
+		Provisioners:        cache,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CfsslIssuer")
-		os.Exit(1)
+		return err
 	}
 
 	if err = (&controllers.CertificateRequestReconciler{
@@ -87,9 +181,25 @@ func main() {
 		Log:      ctrl.Log.WithName("controllers").WithName("CertificateRequest"),
 		Clock:    clock.RealClock{},
 		Recorder: mgr.GetEventRecorderFor("certificaterequests-controller"),
+
+		MaxConcurrentReconciles:  maxConcurrentReconciles,
+		DisableApprovalCheck:     disableApprovalCheck,
+		Auditor:                  auditor,
+		Provisioners:             cache,
+		ClusterResourceNamespace: clusterResourceNamespace,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CertificateRequest")
-		os.Exit(1)
+		return err
+	}
+	if enableApprover {
+		if err = (&controllers.CertificateRequestApproverReconciler{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("CertificateRequestApprover"),
+			Recorder: mgr.GetEventRecorderFor("certificaterequests-approver"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CertificateRequestApprover")
+			return err
+		}
 	}
 
 	if err = (&controllers.CfsslClusterIssuerReconciler{
@@ -97,15 +207,20 @@ func main() {
 		Log:      ctrl.Log.WithName("controllers").WithName("CfsslClusterIssuer"),
 		Clock:    clock.RealClock{},
 		Recorder: mgr.GetEventRecorderFor("cfsslclusterissuer-controller"),
+
+		ClusterResourceNamespace: clusterResourceNamespace,
+		HealthCheckInterval:      healthCheckInterval,
+		Provisioners:             cache,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CfsslClusterIssuer")
-		os.Exit(1)
+		return err
 	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
+		return err
 	}
+	return nil
 }
@@ -0,0 +1,225 @@
+// Package policy evaluates CertificateRequests against CfsslSigningPolicies
+// and selects their signing profiles.
+package policy
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+)
+
+// defaultUsages are the usages cert-manager requests if none are set.
+var defaultUsages = []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageKeyEncipherment}
+
+// Matches returns whether the policy applies to the CertificateRequest.
+func Matches(spec api.CfsslSigningPolicySpec, cr *cmapi.CertificateRequest) bool {
+	if len(spec.Namespaces) > 0 && !contains(spec.Namespaces, cr.Namespace) {
+		return false
+	}
+
+	for _, ref := range spec.IssuerRefs {
+		if ref.Kind == cr.Spec.IssuerRef.Kind && ref.Name == cr.Spec.IssuerRef.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Evaluate checks the CertificateRequest against the policy. The returned
+// error describes every rule the request violates.
+func Evaluate(spec api.CfsslSigningPolicySpec, cr *cmapi.CertificateRequest) error {
+	csr, err := pki.DecodeX509CertificateRequestBytes(cr.Spec.Request)
+	if err != nil {
+		return fmt.Errorf("failed to decode csr: %s", err)
+	}
+
+	var violations []string
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	if spec.DNSNames != nil {
+		for _, name := range csr.DNSNames {
+			if !matchesAny(spec.DNSNames, name, matchDNSName) {
+				violate("dns name %q is not allowed", name)
+			}
+		}
+		// Clients falling back to the common name would otherwise accept
+		// host names the policy does not allow
+		if cn := csr.Subject.CommonName; cn != "" && !matchesAny(spec.DNSNames, cn, matchDNSName) {
+			violate("common name %q is not allowed", cn)
+		}
+	}
+
+	if spec.IPRanges != nil {
+		ranges, err := parseCIDRs(spec.IPRanges)
+		if err != nil {
+			return err
+		}
+		for _, ip := range csr.IPAddresses {
+			if !inRanges(ranges, ip) {
+				violate("ip address %q is not allowed", ip)
+			}
+		}
+	}
+
+	if spec.URIs != nil {
+		for _, uri := range csr.URIs {
+			if !matchesAny(spec.URIs, uri.String(), matchURI) {
+				violate("uri %q is not allowed", uri)
+			}
+		}
+	}
+
+	if spec.EmailAddresses != nil {
+		for _, email := range csr.EmailAddresses {
+			if !matchesAny(spec.EmailAddresses, email, matchEmail) {
+				violate("email address %q is not allowed", email)
+			}
+		}
+	}
+
+	if spec.MaxDuration != nil {
+		duration := cmapi.DefaultCertificateDuration
+		if cr.Spec.Duration != nil {
+			duration = cr.Spec.Duration.Duration
+		}
+		if duration > spec.MaxDuration.Duration {
+			violate("duration %s exceeds the maximum of %s", duration, spec.MaxDuration.Duration)
+		}
+	}
+
+	algorithm, size := publicKey(csr)
+	if spec.KeyAlgorithms != nil && !containsAlgorithm(spec.KeyAlgorithms, algorithm) {
+		violate("key algorithm %s is not allowed", algorithm)
+	}
+	if size < spec.MinKeySize {
+		violate("key size %d is below the minimum of %d", size, spec.MinKeySize)
+	}
+
+	if spec.Usages != nil {
+		usages := cr.Spec.Usages
+		if len(usages) == 0 {
+			usages = defaultUsages
+		}
+		for _, usage := range usages {
+			if !contains(spec.Usages, string(usage)) {
+				violate("usage %q is not allowed", usage)
+			}
+		}
+	}
+
+	if cr.Spec.IsCA && !spec.AllowCA {
+		violate("CA certificates are not allowed")
+	}
+
+	if len(violations) > 0 {
+		return errors.New(strings.Join(violations, ", "))
+	}
+	return nil
+}
+
+// matchDNSName matches name against pattern, where a `*` label matches any
+// single label.
+func matchDNSName(pattern, name string) bool {
+	patternLabels := strings.Split(strings.ToLower(pattern), ".")
+	nameLabels := strings.Split(strings.ToLower(name), ".")
+	if len(patternLabels) != len(nameLabels) {
+		return false
+	}
+
+	for i := range patternLabels {
+		if patternLabels[i] != "*" && patternLabels[i] != nameLabels[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchURI matches uri against pattern, where a `*` matches any sequence of
+// characters other than `/`.
+func matchURI(pattern, uri string) bool {
+	ok, err := path.Match(pattern, uri)
+	return err == nil && ok
+}
+
+// matchEmail matches email against pattern ignoring case, where a `*`
+// matches any sequence of characters other than `/`.
+func matchEmail(pattern, email string) bool {
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(email))
+	return err == nil && ok
+}
+
+func matchesAny(patterns []string, value string, match func(pattern, value string) bool) bool {
+	for _, pattern := range patterns {
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	ranges := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip range %q: %s", cidr, err)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+func inRanges(ranges []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ranges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicKey returns the algorithm and size in bits of the csr's public key.
+func publicKey(csr *x509.CertificateRequest) (api.KeyAlgorithm, int) {
+	switch pub := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return api.RSAKeyAlgorithm, pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return api.ECDSAKeyAlgorithm, pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return api.Ed25519KeyAlgorithm, 8 * ed25519.PublicKeySize
+	default:
+		return api.KeyAlgorithm(csr.PublicKeyAlgorithm.String()), 0
+	}
+}
+
+func containsAlgorithm(algorithms []api.KeyAlgorithm, algorithm api.KeyAlgorithm) bool {
+	for _, a := range algorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package policy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMatches(t *testing.T) {
+	spec := api.CfsslSigningPolicySpec{
+		IssuerRefs: []api.PolicyIssuerReference{{Kind: "CfsslIssuer", Name: "issuer"}},
+		Namespaces: []string{"team-a"},
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		ref       cmmeta.ObjectReference
+		want      bool
+	}{
+		{
+			name:      "matching issuer and namespace",
+			namespace: "team-a",
+			ref:       cmmeta.ObjectReference{Kind: "CfsslIssuer", Name: "issuer"},
+			want:      true,
+		},
+		{
+			name:      "other namespace",
+			namespace: "team-b",
+			ref:       cmmeta.ObjectReference{Kind: "CfsslIssuer", Name: "issuer"},
+		},
+		{
+			name:      "other issuer kind",
+			namespace: "team-a",
+			ref:       cmmeta.ObjectReference{Kind: "CfsslClusterIssuer", Name: "issuer"},
+		},
+		{
+			name:      "other issuer name",
+			namespace: "team-a",
+			ref:       cmmeta.ObjectReference{Kind: "CfsslIssuer", Name: "other"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &cmapi.CertificateRequest{
+				ObjectMeta: metav1.ObjectMeta{Namespace: tt.namespace},
+				Spec:       cmapi.CertificateRequestSpec{IssuerRef: tt.ref},
+			}
+			assert.Equal(t, tt.want, Matches(spec, cr))
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/team-a/sa/app")
+	template := &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "app.example.com"},
+		DNSNames:       []string{"app.example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{spiffe},
+		EmailAddresses: []string{"App@Example.com"},
+	}
+
+	spec := api.CfsslSigningPolicySpec{
+		DNSNames:       []string{"*.example.com"},
+		IPRanges:       []string{"10.0.0.0/8"},
+		URIs:           []string{"spiffe://cluster.local/ns/*/sa/*"},
+		EmailAddresses: []string{"*@example.com"},
+		MaxDuration:    &metav1.Duration{Duration: 24 * time.Hour},
+		KeyAlgorithms:  []api.KeyAlgorithm{api.RSAKeyAlgorithm},
+		MinKeySize:     2048,
+		Usages:         []string{"digital signature", "key encipherment", "server auth"},
+	}
+
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		csr     *x509.CertificateRequest
+		modify  func(*cmapi.CertificateRequest)
+		wantErr string
+	}{
+		{
+			name: "allowed",
+			key:  rsaKey,
+			csr:  template,
+		},
+		{
+			name:    "dns name",
+			key:     rsaKey,
+			csr:     &x509.CertificateRequest{DNSNames: []string{"app.sub.example.com"}},
+			wantErr: `dns name "app.sub.example.com" is not allowed`,
+		},
+		{
+			// the common name must not smuggle in a host name the dns
+			// names may not have
+			name: "common name",
+			key:  rsaKey,
+			csr: &x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "bank.example.org"},
+				DNSNames: []string{"app.example.com"},
+			},
+			wantErr: `common name "bank.example.org" is not allowed`,
+		},
+		{
+			name:    "email address",
+			key:     rsaKey,
+			csr:     &x509.CertificateRequest{EmailAddresses: []string{"app@example.org"}},
+			wantErr: `email address "app@example.org" is not allowed`,
+		},
+		{
+			name:    "ip address",
+			key:     rsaKey,
+			csr:     &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("192.168.0.1")}},
+			wantErr: `ip address "192.168.0.1" is not allowed`,
+		},
+		{
+			name: "uri",
+			key:  rsaKey,
+			csr: &x509.CertificateRequest{URIs: []*url.URL{
+				{Scheme: "spiffe", Host: "other.local", Path: "/ns/team-a/sa/app"},
+			}},
+			wantErr: `uri "spiffe://other.local/ns/team-a/sa/app" is not allowed`,
+		},
+		{
+			name: "duration",
+			key:  rsaKey,
+			csr:  template,
+			modify: func(cr *cmapi.CertificateRequest) {
+				cr.Spec.Duration = &metav1.Duration{Duration: 48 * time.Hour}
+			},
+			wantErr: "duration 48h0m0s exceeds the maximum of 24h0m0s",
+		},
+		{
+			name:    "default duration",
+			key:     rsaKey,
+			csr:     template,
+			modify:  func(cr *cmapi.CertificateRequest) { cr.Spec.Duration = nil },
+			wantErr: "duration 2160h0m0s exceeds the maximum of 24h0m0s",
+		},
+		{
+			name:    "key algorithm",
+			key:     ecKey,
+			csr:     template,
+			wantErr: "key algorithm ECDSA is not allowed, key size 256 is below the minimum of 2048",
+		},
+		{
+			name: "usages",
+			key:  rsaKey,
+			csr:  template,
+			modify: func(cr *cmapi.CertificateRequest) {
+				cr.Spec.Usages = []cmapi.KeyUsage{cmapi.UsageClientAuth}
+			},
+			wantErr: `usage "client auth" is not allowed`,
+		},
+		{
+			name:    "is ca",
+			key:     rsaKey,
+			csr:     template,
+			modify:  func(cr *cmapi.CertificateRequest) { cr.Spec.IsCA = true },
+			wantErr: "CA certificates are not allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := x509.CreateCertificateRequest(rand.Reader, tt.csr, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cr := &cmapi.CertificateRequest{
+				Spec: cmapi.CertificateRequestSpec{
+					Request:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+					Duration: &metav1.Duration{Duration: time.Hour},
+				},
+			}
+			if tt.modify != nil {
+				tt.modify(cr)
+			}
+
+			err = Evaluate(spec, cr)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestEvaluateUnconstrained(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{"anything.example.org"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr := &cmapi.CertificateRequest{
+		Spec: cmapi.CertificateRequestSpec{
+			Request: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+			Usages:  []cmapi.KeyUsage{cmapi.UsageClientAuth},
+		},
+	}
+
+	assert.NoError(t, Evaluate(api.CfsslSigningPolicySpec{}, cr))
+}
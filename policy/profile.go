@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+)
+
+// ProfileAnnotation selects the signing profile of a CertificateRequest
+// among the AllowedProfileOverrides of its issuer. cert-manager copies it
+// from the annotations of Certificates.
+const ProfileAnnotation = "certmanager.thg.io/profile"
+
+// Profile returns the signing profile of the CertificateRequest: the
+// profile selected by its ProfileAnnotation, the profile of the first of
+// the issuer's ProfileRules it matches, or the issuer's Profile if it
+// matches none. An error is returned if the annotation selects a profile
+// the issuer does not allow.
+func Profile(spec api.CfsslIssuerSpec, cr *cmapi.CertificateRequest) (string, error) {
+	if profile, ok := cr.Annotations[ProfileAnnotation]; ok {
+		if !contains(spec.AllowedProfileOverrides, profile) {
+			return "", fmt.Errorf("profile %q requested by annotation %s is not listed in allowedProfileOverrides",
+				profile, ProfileAnnotation)
+		}
+		return profile, nil
+	}
+
+	// Rules on SANs do not match requests whose csr cannot be decoded,
+	// which fail to be signed anyway
+	csr, _ := pki.DecodeX509CertificateRequestBytes(cr.Spec.Request)
+
+	for _, rule := range spec.ProfileRules {
+		if matchesRule(rule, cr, csr) {
+			return rule.Profile, nil
+		}
+	}
+	return spec.Profile, nil
+}
+
+func matchesRule(rule api.ProfileRule, cr *cmapi.CertificateRequest, csr *x509.CertificateRequest) bool {
+	if rule.IsCA != nil && *rule.IsCA != cr.Spec.IsCA {
+		return false
+	}
+
+	if rule.Usages != nil {
+		usages := cr.Spec.Usages
+		if len(usages) == 0 {
+			usages = defaultUsages
+		}
+		for _, usage := range rule.Usages {
+			if !containsUsage(usages, usage) {
+				return false
+			}
+		}
+	}
+
+	if rule.MinDuration != nil || rule.MaxDuration != nil {
+		duration := cmapi.DefaultCertificateDuration
+		if cr.Spec.Duration != nil {
+			duration = cr.Spec.Duration.Duration
+		}
+		if rule.MinDuration != nil && duration < rule.MinDuration.Duration {
+			return false
+		}
+		if rule.MaxDuration != nil && duration > rule.MaxDuration.Duration {
+			return false
+		}
+	}
+
+	if rule.DNSNames != nil {
+		if csr == nil || len(csr.DNSNames) == 0 {
+			return false
+		}
+		for _, name := range csr.DNSNames {
+			if !matchesAny(rule.DNSNames, name, matchDNSName) {
+				return false
+			}
+		}
+	}
+
+	if rule.URIs != nil {
+		if csr == nil || len(csr.URIs) == 0 {
+			return false
+		}
+		for _, uri := range csr.URIs {
+			if !matchesAny(rule.URIs, uri.String(), matchURI) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func containsUsage(usages []cmapi.KeyUsage, usage string) bool {
+	for _, u := range usages {
+		if string(u) == usage {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package policy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"testing"
+	"time"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProfile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/team-a/sa/app")
+	isCA := true
+	spec := api.CfsslIssuerSpec{
+		Profile: "default",
+		ProfileRules: []api.ProfileRule{
+			{Profile: "intermediate", IsCA: &isCA},
+			{Profile: "workload", URIs: []string{"spiffe://cluster.local/ns/*/sa/*"}},
+			{Profile: "short-lived", MaxDuration: &metav1.Duration{Duration: time.Hour}},
+			{Profile: "server", Usages: []string{"server auth"}, DNSNames: []string{"*.example.com"}},
+			{Profile: "client", Usages: []string{"client auth"}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		csr    *x509.CertificateRequest
+		modify func(*cmapi.CertificateRequest)
+		want   string
+	}{
+		{
+			name: "ca",
+			csr:  &x509.CertificateRequest{},
+			modify: func(cr *cmapi.CertificateRequest) {
+				cr.Spec.IsCA = true
+				cr.Spec.Usages = []cmapi.KeyUsage{cmapi.UsageServerAuth}
+			},
+			want: "intermediate",
+		},
+		{
+			name: "uri",
+			csr:  &x509.CertificateRequest{URIs: []*url.URL{spiffe}},
+			want: "workload",
+		},
+		{
+			name: "duration",
+			csr:  &x509.CertificateRequest{},
+			modify: func(cr *cmapi.CertificateRequest) {
+				cr.Spec.Duration = &metav1.Duration{Duration: 30 * time.Minute}
+			},
+			want: "short-lived",
+		},
+		{
+			name: "usages and dns names",
+			csr:  &x509.CertificateRequest{DNSNames: []string{"app.example.com"}},
+			modify: func(cr *cmapi.CertificateRequest) {
+				cr.Spec.Usages = []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageServerAuth}
+			},
+			want: "server",
+		},
+		{
+			name: "dns name not matching",
+			csr:  &x509.CertificateRequest{DNSNames: []string{"app.example.org"}},
+			modify: func(cr *cmapi.CertificateRequest) {
+				cr.Spec.Usages = []cmapi.KeyUsage{cmapi.UsageServerAuth}
+			},
+			want: "default",
+		},
+		{
+			name: "usages",
+			csr:  &x509.CertificateRequest{},
+			modify: func(cr *cmapi.CertificateRequest) {
+				cr.Spec.Usages = []cmapi.KeyUsage{cmapi.UsageClientAuth}
+			},
+			want: "client",
+		},
+		{
+			name: "no matching rule",
+			csr:  &x509.CertificateRequest{DNSNames: []string{"app.example.com"}},
+			want: "default",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := x509.CreateCertificateRequest(rand.Reader, tt.csr, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cr := &cmapi.CertificateRequest{
+				Spec: cmapi.CertificateRequestSpec{
+					Request: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+				},
+			}
+			if tt.modify != nil {
+				tt.modify(cr)
+			}
+
+			profile, err := Profile(spec, cr)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, profile)
+			}
+		})
+	}
+}
+
+func TestProfileOverride(t *testing.T) {
+	isCA := true
+	spec := api.CfsslIssuerSpec{
+		Profile:                 "default",
+		ProfileRules:            []api.ProfileRule{{Profile: "intermediate", IsCA: &isCA}},
+		AllowedProfileOverrides: []string{"long-lived-client"},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+		wantErr     string
+	}{
+		{
+			name: "no override",
+			want: "intermediate",
+		},
+		{
+			name:        "allowed",
+			annotations: map[string]string{ProfileAnnotation: "long-lived-client"},
+			want:        "long-lived-client",
+		},
+		{
+			name:        "not allowed",
+			annotations: map[string]string{ProfileAnnotation: "intermediate"},
+			wantErr: `profile "intermediate" requested by annotation certmanager.thg.io/profile ` +
+				`is not listed in allowedProfileOverrides`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &cmapi.CertificateRequest{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+				Spec:       cmapi.CertificateRequestSpec{IsCA: true},
+			}
+
+			profile, err := Profile(spec, cr)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, profile)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package provisioners
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenDuration     = time.Minute
+	defaultBreakerHalfOpenRequests = 1
+)
+
+// breakers holds the circuit breaker of each issuer, so that its state
+// survives provisioners being rebuilt.
+var breakers = new(sync.Map)
+
+var (
+	circuitObserversMu  sync.RWMutex
+	circuitObservers    = make(map[int]CircuitObserver)
+	nextCircuitObserver int
+)
+
+// CircuitObserver is notified of the state changes of circuit breakers.
+type CircuitObserver func(kind string, key types.NamespacedName, state CircuitState)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through.
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a limited number of trial requests through.
+	CircuitHalfOpen
+	// CircuitOpen rejects all requests.
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "Closed"
+	case CircuitHalfOpen:
+		return "HalfOpen"
+	case CircuitOpen:
+		return "Open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitOpenError is returned by Sign while the circuit breaker of the
+// issuer is open.
+type CircuitOpenError struct {
+	// RetryAfter is the time until the breaker lets requests through again.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open, retrying in %s", e.RetryAfter)
+}
+
+// CircuitBreaker stops sending signing requests to cfssl after repeated
+// transient failures. Once open, it lets trial requests through after a
+// while and closes again if they succeed.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	state    CircuitState
+	failures int32
+	openedAt time.Time
+	trials   int32
+
+	failureThreshold int32
+	openDuration     time.Duration
+	halfOpenRequests int32
+
+	labels []string
+}
+
+// CircuitBreakerFor returns the circuit breaker of the issuer of the given
+// kind, configured by policy.
+func CircuitBreakerFor(kind string, key types.NamespacedName, policy *api.CircuitBreakerPolicy) *CircuitBreaker {
+	b, _ := breakers.LoadOrStore(key, &CircuitBreaker{
+		labels: []string{kind, key.Namespace, key.Name},
+	})
+	breaker := b.(*CircuitBreaker)
+	breaker.configure(policy)
+	return breaker
+}
+
+// OnCircuitStateChange registers f to be called whenever the circuit breaker
+// of an issuer changes state, e.g. to update the status of the issuer, until
+// the returned function is called. f is called while the breaker is locked,
+// so it must not block.
+func OnCircuitStateChange(f CircuitObserver) (remove func()) {
+	circuitObserversMu.Lock()
+	defer circuitObserversMu.Unlock()
+
+	id := nextCircuitObserver
+	nextCircuitObserver++
+	circuitObservers[id] = f
+
+	return func() {
+		circuitObserversMu.Lock()
+		defer circuitObserversMu.Unlock()
+
+		delete(circuitObservers, id)
+	}
+}
+
+// RemoveCircuitBreaker removes the circuit breaker of the issuer.
+func RemoveCircuitBreaker(key types.NamespacedName) {
+	if b, ok := breakers.LoadAndDelete(key); ok {
+		circuitBreakerState.DeleteLabelValues(b.(*CircuitBreaker).labels...)
+	}
+}
+
+func (b *CircuitBreaker) configure(policy *api.CircuitBreakerPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failureThreshold = defaultBreakerFailureThreshold
+	b.openDuration = defaultBreakerOpenDuration
+	b.halfOpenRequests = defaultBreakerHalfOpenRequests
+	if policy != nil {
+		if policy.FailureThreshold > 0 {
+			b.failureThreshold = policy.FailureThreshold
+		}
+		if policy.OpenDuration != nil {
+			b.openDuration = policy.OpenDuration.Duration
+		}
+		if policy.HalfOpenRequests > 0 {
+			b.halfOpenRequests = policy.HalfOpenRequests
+		}
+	}
+
+	b.setState(b.state)
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && !now().Before(b.openedAt.Add(b.openDuration)) {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// RetryAfter returns the time until an open breaker lets trial requests
+// through, or zero if it is not open.
+func (b *CircuitBreaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != CircuitOpen {
+		return 0
+	}
+	if wait := b.openedAt.Add(b.openDuration).Sub(now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// allow returns a CircuitOpenError if the request must not be sent.
+func (b *CircuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen {
+		retryAt := b.openedAt.Add(b.openDuration)
+		if wait := retryAt.Sub(now()); wait > 0 {
+			return &CircuitOpenError{RetryAfter: wait}
+		}
+		b.trials = 0
+		b.setState(CircuitHalfOpen)
+	}
+
+	if b.state == CircuitHalfOpen {
+		if b.trials >= b.halfOpenRequests {
+			return &CircuitOpenError{RetryAfter: b.openDuration}
+		}
+		b.trials++
+	}
+
+	return nil
+}
+
+// record updates the breaker with the result of a request it allowed.
+// Only transient errors count as failures, as permanent errors are answers
+// of a working cfssl server.
+func (b *CircuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || !Retryable(err) {
+		b.failures = 0
+		b.setState(CircuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = now()
+		b.setState(CircuitOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(state CircuitState) {
+	changed := b.state != state
+	b.state = state
+	circuitBreakerState.WithLabelValues(b.labels...).Set(float64(state))
+	if changed {
+		b.notify(state)
+	}
+}
+
+// notify calls the observers registered by OnCircuitStateChange.
+func (b *CircuitBreaker) notify(state CircuitState) {
+	circuitObserversMu.RLock()
+	defer circuitObserversMu.RUnlock()
+
+	key := types.NamespacedName{Namespace: b.labels[1], Name: b.labels[2]}
+	for _, f := range circuitObservers {
+		f(b.labels[0], key, state)
+	}
+}
@@ -0,0 +1,152 @@
+package provisioners
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	cfsslerr "github.com/cloudflare/cfssl/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	current := time.Now()
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	key := types.NamespacedName{Namespace: "default", Name: "breaker"}
+	defer RemoveCircuitBreaker(key)
+
+	b := CircuitBreakerFor("CfsslIssuer", key, &api.CircuitBreakerPolicy{
+		FailureThreshold: 2,
+		OpenDuration:     &meta.Duration{Duration: time.Minute},
+	})
+	assert.Same(t, b, CircuitBreakerFor("CfsslIssuer", key, nil), "breaker should be shared by provisioners of an issuer")
+	// Restore the policy reset by the lookup above
+	b.configure(&api.CircuitBreakerPolicy{FailureThreshold: 2, OpenDuration: &meta.Duration{Duration: time.Minute}})
+
+	transient := errors.New("connection refused")
+	permanent := &SignError{Class: PolicyViolation}
+
+	assert.NoError(t, b.allow())
+	b.record(transient)
+	assert.Equal(t, CircuitClosed, b.State(), "breaker opened before reaching the threshold")
+
+	assert.NoError(t, b.allow())
+	b.record(transient)
+	assert.Equal(t, CircuitOpen, b.State(), "breaker not opened after reaching the threshold")
+
+	var openErr *CircuitOpenError
+	if assert.True(t, errors.As(b.allow(), &openErr), "open breaker should reject requests") {
+		assert.Equal(t, time.Minute, openErr.RetryAfter)
+	}
+
+	current = current.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.NoError(t, b.allow(), "half-open breaker should let a trial request through")
+	assert.Error(t, b.allow(), "half-open breaker should only let one trial request through")
+	b.record(transient)
+	assert.Equal(t, CircuitOpen, b.State(), "failed trial request should open the breaker")
+
+	current = current.Add(time.Minute)
+	assert.NoError(t, b.allow())
+	b.record(permanent)
+	assert.Equal(t, CircuitClosed, b.State(), "permanent errors should close the breaker")
+	assert.NoError(t, b.allow())
+}
+
+func TestCircuitStateChanges(t *testing.T) {
+	current := time.Now()
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	key := types.NamespacedName{Namespace: "default", Name: "breaker-changes"}
+	defer RemoveCircuitBreaker(key)
+
+	changes := make(chan CircuitState, 2)
+	remove := OnCircuitStateChange(func(kind string, changed types.NamespacedName, state CircuitState) {
+		if kind == "CfsslIssuer" && changed == key {
+			changes <- state
+		}
+	})
+	defer remove()
+
+	b := CircuitBreakerFor("CfsslIssuer", key, &api.CircuitBreakerPolicy{FailureThreshold: 1})
+	assert.Zero(t, b.RetryAfter())
+
+	b.record(errors.New("connection refused"))
+	assert.Equal(t, CircuitOpen, waitForState(t, changes))
+	assert.Equal(t, time.Minute, b.RetryAfter())
+
+	current = current.Add(time.Minute)
+	assert.Zero(t, b.RetryAfter())
+	assert.NoError(t, b.allow())
+	assert.Equal(t, CircuitHalfOpen, waitForState(t, changes))
+
+	b.record(nil)
+	assert.Equal(t, CircuitClosed, waitForState(t, changes))
+
+	// recording a result without changing the state notifies no one
+	b.record(nil)
+	select {
+	case state := <-changes:
+		t.Fatalf("unexpected change to %s", state)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// removed observers are not notified
+	remove()
+	b.record(errors.New("connection refused"))
+	assert.Equal(t, CircuitOpen, b.State())
+	select {
+	case state := <-changes:
+		t.Fatalf("unexpected change to %s after removing the observer", state)
+	default:
+	}
+}
+
+func waitForState(t *testing.T, changes <-chan CircuitState) CircuitState {
+	t.Helper()
+
+	select {
+	case state := <-changes:
+		return state
+	case <-time.After(time.Second):
+		t.Fatal("breaker did not notify its change")
+		return 0
+	}
+}
+
+func TestProvisionerCircuitBreaker(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	key := types.NamespacedName{Namespace: "default", Name: "breaker-sign"}
+	defer RemoveCircuitBreaker(key)
+	breaker := CircuitBreakerFor("CfsslIssuer", key, &api.CircuitBreakerPolicy{FailureThreshold: 1})
+
+	pro, err := New(api.CfsslIssuerSpec{
+		URL:      mockServer.URL,
+		Profile:  mock.ServerErrorProfile,
+		CABundle: encodeCert(mockServer.Certificate()),
+	}, WithCircuitBreaker(breaker))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	_, err = pro.Sign(context.Background(), validCSR)
+	var serr *SignError
+	if assert.True(t, errors.As(err, &serr), "expected a SignError, got %v", err) {
+		assert.Equal(t, int(cfsslerr.PrivateKeyError)+int(cfsslerr.Unavailable), serr.Code)
+	}
+
+	_, err = pro.Sign(context.Background(), validCSR)
+	var openErr *CircuitOpenError
+	assert.True(t, errors.As(err, &openErr), "expected a CircuitOpenError, got %v", err)
+}
@@ -0,0 +1,209 @@
+package provisioners
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+	"github.com/cloudflare/cfssl/info"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultCAChainRefreshInterval = time.Hour
+
+// caChain holds the PEM chain of the issuing CA. If discovery is enabled it
+// is fetched from the cfssl info endpoint and refreshed once it is older
+// than the refresh interval.
+type caChain struct {
+	mu       sync.RWMutex
+	pem      []byte
+	discover bool
+	interval time.Duration
+	fetched  time.Time
+
+	// bundle holds the certificates of the CA bundle, which complete a
+	// discovered chain up to its root.
+	bundle []*x509.Certificate
+}
+
+func newCAChain(spec api.CfsslIssuerSpec, bundle []byte) *caChain {
+	c := &caChain{
+		pem:      bundle,
+		discover: spec.DiscoverCAChain,
+		interval: defaultCAChainRefreshInterval,
+	}
+	if len(spec.CAChain) > 0 {
+		c.pem = spec.CAChain
+	}
+	if spec.CAChainRefreshInterval != nil {
+		c.interval = spec.CAChainRefreshInterval.Duration
+	}
+	if c.discover {
+		c.pem = nil
+		c.bundle, _ = pki.DecodeX509CertificateChainBytes(bundle)
+	}
+	return c
+}
+
+func (c *caChain) get() []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.pem
+}
+
+func (c *caChain) set(chain []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pem = chain
+	c.fetched = now()
+}
+
+func (c *caChain) stale() bool {
+	if !c.discover {
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.pem == nil || now().Sub(c.fetched) >= c.interval
+}
+
+// RefreshCAChain fetches the signing certificate from the cfssl info
+// endpoint and uses it as CA chain from then on. It is a no-op unless CA
+// chain discovery is enabled. The request is bounded by the sign timeout,
+// which is shared by the endpoints like for signing requests.
+func (cf *CfsslProvisioner) RefreshCAChain(ctx context.Context) error {
+	if !cf.ca.discover {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cf.timeout)
+	defer cancel()
+
+	req, err := json.Marshal(info.Req{Label: cf.label, Profile: cf.profile})
+	if err != nil {
+		return fmt.Errorf("failed to encode info request: %s", err)
+	}
+
+	candidates := cf.endpoints.candidates()
+	for i, e := range candidates {
+		var resp *info.Resp
+		attemptCtx, cancel := attemptContext(ctx, len(candidates)-i)
+		resp, err = e.remote.Info(attemptCtx, req)
+		cancel()
+		if err != nil {
+			if !Retryable(err) {
+				break
+			}
+			cf.endpoints.recordFailure(e, err)
+			continue
+		}
+		cf.endpoints.recordSuccess(e)
+
+		cert, err := pki.DecodeX509CertificateBytes([]byte(resp.Certificate))
+		if err != nil {
+			return fmt.Errorf("failed to decode discovered CA: %s", err)
+		}
+		var chain []byte
+		for _, c := range cf.ca.complete(cert) {
+			encoded, err := pki.EncodeX509(c)
+			if err != nil {
+				return fmt.Errorf("failed to encode discovered CA: %s", err)
+			}
+			chain = append(chain, encoded...)
+		}
+
+		cf.ca.set(chain)
+		return nil
+	}
+
+	return fmt.Errorf("failed to discover CA chain: %w", err)
+}
+
+// complete returns the chain from the discovered signing certificate up to
+// its root, adding the issuer of each certificate from the CA bundle. If the
+// bundle lacks an issuer, the chain ends early and its last certificate is
+// used as root.
+func (c *caChain) complete(cert *x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{cert}
+	// Each certificate of the bundle is added at most once, even if the
+	// certificates of the bundle issued one another
+	for len(chain) <= len(c.bundle) {
+		last := chain[len(chain)-1]
+		if bytes.Equal(last.RawIssuer, last.RawSubject) {
+			break
+		}
+		issuer := issuerOf(last, c.bundle)
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+	}
+	return chain
+}
+
+// issuerOf returns the certificate among candidates which issued cert, or
+// nil if none did.
+func issuerOf(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if bytes.Equal(candidate.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// caChain returns the chain of the issuing CA, refreshing a discovered
+// chain if it is stale. A previously discovered chain is kept if the
+// refresh fails.
+func (cf *CfsslProvisioner) caChain(ctx context.Context) ([]byte, error) {
+	if cf.ca.stale() {
+		if err := cf.RefreshCAChain(ctx); err != nil && cf.ca.get() == nil {
+			return nil, err
+		}
+	}
+
+	return cf.ca.get(), nil
+}
+
+// CAChainRefreshInterval returns how often the CA chain is discovered again,
+// or zero if discovery is disabled.
+func (cf *CfsslProvisioner) CAChainRefreshInterval() time.Duration {
+	if !cf.ca.discover {
+		return 0
+	}
+	return cf.ca.interval
+}
+
+// CAStatus describes the issuing CA, or returns nil if it is not known yet.
+func (cf *CfsslProvisioner) CAStatus() *api.CAStatus {
+	return cf.ca.status()
+}
+
+// status describes the issuing CA, or returns nil if it is not known yet.
+func (c *caChain) status() *api.CAStatus {
+	chain := c.get()
+	if chain == nil {
+		return nil
+	}
+
+	certs, err := pki.DecodeX509CertificateChainBytes(chain)
+	if err != nil || len(certs) == 0 {
+		return nil
+	}
+
+	return &api.CAStatus{
+		Subject:      certs[0].Subject.String(),
+		SerialNumber: certs[0].SerialNumber.Text(16),
+		NotAfter:     meta.NewTime(certs[0].NotAfter),
+	}
+}
@@ -0,0 +1,123 @@
+package provisioners
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCAChainDiscovery(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	spec := api.CfsslIssuerSpec{
+		URL:                    mockServer.URL,
+		Profile:                "client",
+		CABundle:               encodeCert(mockServer.Certificate()),
+		DiscoverCAChain:        true,
+		CAChainRefreshInterval: &meta.Duration{Duration: time.Minute},
+	}
+	pro, err := New(spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	assert.Nil(t, pro.CAStatus(), "CA should be unknown before discovery")
+	assert.Equal(t, time.Minute, pro.CAChainRefreshInterval())
+
+	// Signing discovers the chain if it was not refreshed before
+	res, err := pro.Sign(context.Background(), validCSR)
+	if err != nil {
+		t.Fatalf("failed to sign csr: %v", err)
+	}
+	if !bytes.Equal(validCABundle, res.CA) {
+		t.Error("returned ca does not match the discovered CA")
+	}
+
+	status := pro.CAStatus()
+	if assert.NotNil(t, status) {
+		assert.Contains(t, status.Subject, "Internet Widgits Pty Ltd")
+		assert.NotEmpty(t, status.SerialNumber)
+		assert.False(t, status.NotAfter.IsZero())
+	}
+}
+
+func TestCAChainRefresh(t *testing.T) {
+	current := time.Now()
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	chain := newCAChain(api.CfsslIssuerSpec{
+		DiscoverCAChain:        true,
+		CAChainRefreshInterval: &meta.Duration{Duration: time.Minute},
+	}, validCABundle)
+
+	assert.Nil(t, chain.get(), "bundle should not be used when discovering the chain")
+	assert.True(t, chain.stale())
+
+	chain.set(validCABundle)
+	assert.False(t, chain.stale())
+
+	current = current.Add(time.Minute)
+	assert.True(t, chain.stale())
+
+	static := newCAChain(api.CfsslIssuerSpec{}, validCABundle)
+	assert.Equal(t, validCABundle, static.get())
+	assert.False(t, static.stale())
+}
+
+func TestCAChainComplete(t *testing.T) {
+	root, _ := newClientCertificate(t)
+	other, _ := newClientCertificate(t)
+
+	// an intermediate CA issued by the root
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "cfssl-issuer intermediate"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageCertSign,
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, root.Leaf, &key.PublicKey, root.PrivateKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	intermediate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	spec := api.CfsslIssuerSpec{DiscoverCAChain: true}
+	bundle := append(encodeCert(other.Leaf), encodeCert(root.Leaf)...)
+
+	// the root is taken from the CA bundle
+	chain := newCAChain(spec, bundle)
+	assert.Equal(t, []*x509.Certificate{intermediate, root.Leaf}, chain.complete(intermediate))
+
+	// a self signed CA is a chain on its own
+	assert.Equal(t, []*x509.Certificate{root.Leaf}, chain.complete(root.Leaf))
+
+	// without the root the intermediate is used as root
+	chain = newCAChain(spec, encodeCert(other.Leaf))
+	assert.Equal(t, []*x509.Certificate{intermediate}, chain.complete(intermediate))
+}
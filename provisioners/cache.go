@@ -0,0 +1,204 @@
+package provisioners
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Cache holds the provisioners of issuers, keyed by the UID and generation
+// of the issuer and the options they were built from. HTTP transports are
+// shared between provisioners with the same TLS configuration, so that
+// connections to cfssl survive provisioners being rebuilt.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[types.UID]*cacheEntry
+
+	transportsMu sync.Mutex
+	transports   map[string]*sharedTransport
+}
+
+type cacheEntry struct {
+	kind        string
+	key         types.NamespacedName
+	generation  int64
+	optionsKey  string
+	provisioner Backend
+}
+
+type sharedTransport struct {
+	transport *http.Transport
+	refs      int
+}
+
+// NewCache returns an empty Cache.
+func NewCache() *Cache {
+	return &Cache{
+		entries:    make(map[types.UID]*cacheEntry),
+		transports: make(map[string]*sharedTransport),
+	}
+}
+
+// Get returns the provisioner of the issuer, unless it has not been built
+// yet or was built from another generation of the issuer or other options,
+// e.g. before a referenced Secret changed.
+func (c *Cache) Get(issuer metav1.Object, opts ...Option) (Backend, bool) {
+	key := newOptions(opts).key()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[issuer.GetUID()]
+	if !ok || e.generation != issuer.GetGeneration() || e.optionsKey != key {
+		return nil, false
+	}
+	return e.provisioner, true
+}
+
+// Build builds the provisioner of an issuer, see NewBackend, sharing the
+// transports of the cache. It is not cached until it is passed to Store,
+// and must be passed to Discard if it is not.
+func (c *Cache) Build(spec api.CfsslIssuerSpec, opts ...Option) (Backend, error) {
+	return NewBackend(spec, append(opts, withTransports(c))...)
+}
+
+// Store caches the provisioner p built by Build as the provisioner of the
+// current generation of the issuer of the given kind, in place of the
+// provisioner stored before, and returns it. If an equivalent provisioner
+// was stored meanwhile, e.g. by a concurrent reconcile, p is discarded and
+// the stored one returned instead.
+func (c *Cache) Store(kind string, issuer metav1.Object, p Backend) Backend {
+	key := optionsKeyOf(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[issuer.GetUID()]; ok {
+		if old.generation == issuer.GetGeneration() && old.optionsKey == key {
+			c.releaseFor(p)
+			return old.provisioner
+		}
+		c.releaseFor(old.provisioner)
+	}
+	c.entries[issuer.GetUID()] = &cacheEntry{
+		kind:        kind,
+		key:         types.NamespacedName{Namespace: issuer.GetNamespace(), Name: issuer.GetName()},
+		generation:  issuer.GetGeneration(),
+		optionsKey:  key,
+		provisioner: p,
+	}
+	return p
+}
+
+// Discard releases the provisioner p built by Build which is not stored,
+// e.g. because it failed to be probed.
+func (c *Cache) Discard(p Backend) {
+	c.releaseFor(p)
+}
+
+// Remove evicts the provisioner of the deleted issuer of the given kind.
+func (c *Cache) Remove(kind string, key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for uid, e := range c.entries {
+		if e.kind == kind && e.key == key {
+			c.releaseFor(e.provisioner)
+			delete(c.entries, uid)
+		}
+	}
+}
+
+// acquire returns the transport shared by provisioners with the TLS
+// configuration identified by key.
+func (c *Cache) acquire(key string, tlsconfig *tls.Config) *http.Transport {
+	c.transportsMu.Lock()
+	defer c.transportsMu.Unlock()
+
+	t, ok := c.transports[key]
+	if !ok {
+		t = &sharedTransport{transport: newTransport(tlsconfig)}
+		c.transports[key] = t
+	}
+	t.refs++
+	return t.transport
+}
+
+// release drops a reference to the transport identified by key, closing it
+// once it is no longer used.
+func (c *Cache) release(key string) {
+	c.transportsMu.Lock()
+	defer c.transportsMu.Unlock()
+
+	t, ok := c.transports[key]
+	if !ok {
+		return
+	}
+	if t.refs--; t.refs <= 0 {
+		t.transport.CloseIdleConnections()
+		delete(c.transports, key)
+	}
+}
+
+// releaseFor releases the transport of a CfsslProvisioner.
+func (c *Cache) releaseFor(p Backend) {
+	if cf, ok := p.(*CfsslProvisioner); ok {
+		c.release(cf.transportKey)
+	}
+}
+
+// optionsKeyOf returns the key of the options p was built from.
+func optionsKeyOf(p Backend) string {
+	switch p := p.(type) {
+	case *CfsslProvisioner:
+		return p.optionsKey
+	case *LocalProvisioner:
+		return p.optionsKey
+	default:
+		return ""
+	}
+}
+
+// key identifies the values of the options which are resolved from
+// referenced Secrets and ConfigMaps, so that provisioners are rebuilt once
+// they change. Shared state such as circuit breakers is not part of it.
+func (o *options) key() string {
+	h := sha256.New()
+	for _, data := range [][]byte{[]byte(o.authKey), o.caBundle, o.caCert, o.caKey} {
+		fmt.Fprintf(h, "%d:", len(data))
+		h.Write(data)
+	}
+	if o.clientCert != nil {
+		for _, der := range o.clientCert.Certificate {
+			fmt.Fprintf(h, "%d:", len(der))
+			h.Write(der)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// transportKey identifies the TLS configuration built from the CA bundle
+// and client certificate.
+func transportKey(caBundle []byte, clientCert *tls.Certificate) string {
+	h := sha256.New()
+	h.Write(caBundle)
+	if clientCert != nil {
+		for _, der := range clientCert.Certificate {
+			h.Write(der)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func newTransport(tlsconfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsconfig
+	return transport
+}
@@ -0,0 +1,169 @@
+package provisioners
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCacheFlow(t *testing.T) {
+	cache := NewCache()
+	issuer := newIssuer("cfssl-issuer", "http://test")
+
+	// nothing has been built yet
+	if _, ok := cache.Get(issuer); ok {
+		t.Fatal("retrieved provisioner when it should have failed")
+	}
+
+	pro, err := cache.Build(issuer.Spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	// provisioners are only cached once stored
+	if _, ok := cache.Get(issuer); ok {
+		t.Fatal("retrieved provisioner which has not been stored")
+	}
+	cache.Store("CfsslIssuer", issuer, pro)
+
+	fetched, ok := cache.Get(issuer)
+	if !ok {
+		t.Fatal("failed to retrieve provisioner")
+	}
+	assert.Same(t, pro, fetched)
+
+	// a new generation of the issuer must be rebuilt
+	issuer.Generation++
+	issuer.Spec.URL = "http://test2"
+	if _, ok := cache.Get(issuer); ok {
+		t.Fatal("retrieved provisioner of an outdated generation")
+	}
+
+	newPro, err := cache.Build(issuer.Spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	cache.Store("CfsslIssuer", issuer, newPro)
+
+	fetched, ok = cache.Get(issuer)
+	if !ok {
+		t.Fatal("failed to retrieve provisioner")
+	}
+	assert.Same(t, newPro, fetched)
+
+	// provisioners built from other options, e.g. after a referenced
+	// Secret changed, must be rebuilt
+	if _, ok := cache.Get(issuer, WithAuthKey("0123456789abcdef")); ok {
+		t.Fatal("retrieved provisioner built from other options")
+	}
+
+	// only the provisioner of the issuer of the given kind is removed
+	cache.Remove("CfsslClusterIssuer", types.NamespacedName{Namespace: "default", Name: "cfssl-issuer"})
+	if _, ok := cache.Get(issuer); !ok {
+		t.Fatal("removed provisioner of another kind")
+	}
+
+	cache.Remove("CfsslIssuer", types.NamespacedName{Namespace: "default", Name: "cfssl-issuer"})
+	if _, ok := cache.Get(issuer); ok {
+		t.Fatal("retrieved provisioner when it should have failed")
+	}
+}
+
+func TestCacheSharesTransports(t *testing.T) {
+	cache := NewCache()
+	issuer1 := newIssuer("cfssl-issuer-1", "http://test")
+	issuer2 := newIssuer("cfssl-issuer-2", "http://test2")
+
+	pro1, err := cache.Build(issuer1.Spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	cache.Store("CfsslIssuer", issuer1, pro1)
+	pro2, err := cache.Build(issuer2.Spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	cache.Store("CfsslIssuer", issuer2, pro2)
+
+	// both issuers trust the same bundle, so they share a transport
+	key := pro1.(*CfsslProvisioner).transportKey
+	assert.Equal(t, key, pro2.(*CfsslProvisioner).transportKey)
+	assert.Len(t, cache.transports, 1)
+	assert.Equal(t, 2, cache.transports[key].refs)
+
+	// rebuilding a provisioner does not leak a reference
+	issuer1.Generation++
+	pro1, err = cache.Build(issuer1.Spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	cache.Store("CfsslIssuer", issuer1, pro1)
+	assert.Equal(t, 2, cache.transports[key].refs)
+
+	// nor does discarding a provisioner which was not stored
+	discarded, err := cache.Build(issuer1.Spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	assert.Equal(t, 3, cache.transports[key].refs)
+	cache.Discard(discarded)
+	assert.Equal(t, 2, cache.transports[key].refs)
+
+	cache.Remove("CfsslIssuer", types.NamespacedName{Namespace: "default", Name: "cfssl-issuer-1"})
+	assert.Equal(t, 1, cache.transports[key].refs)
+
+	cache.Remove("CfsslIssuer", types.NamespacedName{Namespace: "default", Name: "cfssl-issuer-2"})
+	assert.Empty(t, cache.transports)
+}
+
+func TestCacheStoreKeepsEquivalentProvisioner(t *testing.T) {
+	cache := NewCache()
+	issuer := newIssuer("cfssl-issuer", "http://test")
+
+	// two reconciles building the provisioner of the same issuer at once
+	pro1, err := cache.Build(issuer.Spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	pro2, err := cache.Build(issuer.Spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	assert.Same(t, pro1, cache.Store("CfsslIssuer", issuer, pro1))
+	assert.Same(t, pro1, cache.Store("CfsslIssuer", issuer, pro2))
+	assert.Equal(t, 1, cache.transports[pro1.(*CfsslProvisioner).transportKey].refs)
+
+	// a provisioner built from other options replaces the stored one
+	pro3, err := cache.Build(issuer.Spec, WithAuthKey("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	assert.Same(t, pro3, cache.Store("CfsslIssuer", issuer, pro3))
+
+	fetched, ok := cache.Get(issuer, WithAuthKey("0123456789abcdef"))
+	if !ok {
+		t.Fatal("failed to retrieve provisioner")
+	}
+	assert.Same(t, pro3, fetched)
+}
+
+func newIssuer(name, url string) *api.CfsslIssuer {
+	return &api.CfsslIssuer{
+		ObjectMeta: meta.ObjectMeta{
+			Namespace:  "default",
+			Name:       name,
+			UID:        types.UID(name),
+			Generation: 1,
+		},
+		Spec: api.CfsslIssuerSpec{
+			URL:      url,
+			Profile:  "server",
+			CABundle: validCABundle,
+		},
+	}
+}
@@ -1,152 +1,509 @@
 package provisioners
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
-	"sync"
+	"time"
 
 	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/OpenSource-THG/cfssl-issuer/tracing"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/cert-manager/cert-manager/pkg/util/pki"
-	cfssl "github.com/cloudflare/cfssl/api/client"
-	cfsslerr "github.com/cloudflare/cfssl/errors"
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/cloudflare/cfssl/auth"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/types"
 )
 
 var (
-	_ Provisioner = &CfsslProvisioner{}
+	_ Backend = &CfsslProvisioner{}
+	_ Backend = &LocalProvisioner{}
 
-	ErrInvalidBundle = errors.New("invalid ca bundle")
-
-	p = new(sync.Map)
+	ErrInvalidBundle  = errors.New("invalid ca bundle")
+	ErrInvalidAuthKey = errors.New("invalid auth key")
+	ErrNoEndpoints    = errors.New("no cfssl endpoints configured")
 )
 
+const defaultSignTimeout = 30 * time.Second
+
 type Provisioner interface {
-	Sign([]byte) ([]byte, []byte, error)
+	// Sign signs the PEM encoded CSR. The request to cfssl is aborted when
+	// ctx is done.
+	Sign(ctx context.Context, csr []byte, opts ...SignOption) (*SignResult, error)
+}
+
+// SignOption configures a single signing request.
+type SignOption func(*signOptions)
+
+type signOptions struct {
+	duration time.Duration
+	profile  string
+	usages   []cmapi.KeyUsage
+	isCA     bool
+}
+
+// WithDuration requests the validity of the signed certificate, starting
+// now. Durations above the MaxDuration of the issuer are reduced to it.
+// Without a duration the expiry of the signing profile applies. Only the
+// LocalProvisioner honors it, as the sign API of cfssl does not accept a
+// validity, so a Cfssl Server always signs with the expiry of the profile.
+func WithDuration(d time.Duration) SignOption {
+	return func(o *signOptions) {
+		o.duration = d
+	}
+}
+
+// WithProfile signs the certificate with the given profile instead of the
+// profile of the issuer. An empty profile keeps the profile of the issuer.
+func WithProfile(profile string) SignOption {
+	return func(o *signOptions) {
+		o.profile = profile
+	}
+}
+
+// WithUsages requests the key usages of the signed certificate, as named
+// by cert-manager, and whether it is a CA. The certificate is verified to
+// have them, unless verification is off.
+func WithUsages(usages []cmapi.KeyUsage, isCA bool) SignOption {
+	return func(o *signOptions) {
+		o.usages = usages
+		o.isCA = isCA
+	}
+}
+
+func newSignOptions(opts []SignOption) *signOptions {
+	o := &signOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// profileOr returns the requested profile, or def if none was requested.
+func (o *signOptions) profileOr(def string) string {
+	if o.profile != "" {
+		return o.profile
+	}
+	return def
+}
+
+// validity returns the validity window of the requested duration, reduced
+// to maxDuration if set, or zero times if no duration was requested.
+func (o *signOptions) validity(maxDuration time.Duration) (notBefore, notAfter time.Time) {
+	d := o.duration
+	if d <= 0 {
+		return time.Time{}, time.Time{}
+	}
+	if maxDuration > 0 && d > maxDuration {
+		d = maxDuration
+	}
+
+	notBefore = now().UTC().Truncate(time.Second)
+	return notBefore, notBefore.Add(d)
+}
+
+// Backend is the Provisioner of an issuer, whose health and CA are reported
+// in the status of the issuer.
+type Backend interface {
+	Provisioner
+
+	// Probe checks that certificates can be signed.
+	Probe(ctx context.Context) error
+	// RefreshCAChain discovers the chain of the issuing CA, if enabled.
+	RefreshCAChain(ctx context.Context) error
+	// CAChainRefreshInterval returns how often the CA chain is discovered
+	// again, or zero if discovery is disabled.
+	CAChainRefreshInterval() time.Duration
+	// CAStatus describes the issuing CA, or returns nil if it is not known.
+	CAStatus() *api.CAStatus
+	// EndpointStatus returns the health of the Cfssl Server endpoints.
+	EndpointStatus() []api.EndpointStatus
+}
+
+// NewBackend returns the provisioner of the signing mode of spec, see New
+// and NewLocal.
+func NewBackend(spec api.CfsslIssuerSpec, opts ...Option) (Backend, error) {
+	if spec.Mode == api.LocalMode {
+		lp, err := NewLocal(spec, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return lp, nil
+	}
+
+	cf, err := New(spec, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// SignResult is the outcome of a successful signing request.
+type SignResult struct {
+	// Certificate is the PEM encoded signed certificate followed by the
+	// intermediate CAs.
+	Certificate []byte
+
+	// CA is the PEM encoded root CA.
+	CA []byte
+
+	// Endpoint is the url of the Cfssl Server which signed the certificate.
+	Endpoint string
+
+	// VerifyErr describes why the certificate failed verification, if the
+	// issuer only warns about such certificates.
+	VerifyErr error
 }
 
 type certificateRequest struct {
 	CSR     string `json:"certificate_request"`
 	Profile string `json:"profile"`
+	Label   string `json:"label,omitempty"`
 }
 
 type CfsslProvisioner struct {
-	client  cfssl.Remote
-	profile string
-	ca      []byte
+	endpoints *endpointSet
+	provider  auth.Provider
+	profile   string
+	label     string
+	timeout   time.Duration
+	labels    []string
+	breaker   *CircuitBreaker
+	limiter   *Limiter
+
+	// verification controls the verification of signed certificates.
+	verification api.VerificationMode
+
+	// ca is the chain of the issuing CA, which may differ from the bundle
+	// used to verify the TLS connection to cfssl.
+	ca *caChain
+
+	// transportKey identifies the TLS configuration of the transport.
+	transportKey string
+	// optionsKey identifies the options the provisioner was built from.
+	optionsKey string
 }
 
-func New(spec api.CfsslIssuerSpec) (*CfsslProvisioner, error) {
+// Option configures optional settings of a CfsslProvisioner which are not
+// part of the issuer spec, e.g. values resolved from referenced Secrets.
+type Option func(*options)
+
+type options struct {
+	authKey    string
+	clientCert *tls.Certificate
+	caBundle   []byte
+	labels     []string
+	breaker    *CircuitBreaker
+	limiter    *Limiter
+	transports *Cache
+	caCert     []byte
+	caKey      []byte
+}
+
+// WithAuthKey configures the hex encoded key used to authenticate signing
+// requests with the cfssl standard auth provider.
+func WithAuthKey(key string) Option {
+	return func(o *options) {
+		o.authKey = strings.TrimSpace(key)
+	}
+}
+
+// WithCABundle configures the CA bundle used instead of spec.CABundle,
+// e.g. when it is loaded from a referenced Secret or ConfigMap.
+func WithCABundle(bundle []byte) Option {
+	return func(o *options) {
+		o.caBundle = bundle
+	}
+}
+
+// WithClientCertificate configures the key pair presented to the cfssl
+// server as TLS client certificate.
+func WithClientCertificate(cert tls.Certificate) Option {
+	return func(o *options) {
+		o.clientCert = &cert
+	}
+}
+
+// WithIssuer configures the issuer the provisioner belongs to, which labels
+// its metrics.
+func WithIssuer(kind string, key types.NamespacedName) Option {
+	return func(o *options) {
+		o.labels = []string{kind, key.Namespace, key.Name}
+	}
+}
+
+// WithCircuitBreaker configures the circuit breaker guarding signing
+// requests, see CircuitBreakerFor.
+func WithCircuitBreaker(b *CircuitBreaker) Option {
+	return func(o *options) {
+		o.breaker = b
+	}
+}
+
+// WithLimiter configures the rate limiter of signing requests, see
+// LimiterFor.
+func WithLimiter(l *Limiter) Option {
+	return func(o *options) {
+		o.limiter = l
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{labels: []string{"", "", ""}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// withTransports configures the cache whose shared transports are used.
+func withTransports(c *Cache) Option {
+	return func(o *options) {
+		o.transports = c
+	}
+}
+
+func New(spec api.CfsslIssuerSpec, opts ...Option) (*CfsslProvisioner, error) {
+	o := newOptions(opts)
+
+	if len(endpointURLs(spec)) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	caBundle := spec.CABundle
+	if o.caBundle != nil {
+		caBundle = o.caBundle
+	}
+
 	rootCAs, _ := x509.SystemCertPool()
 	if rootCAs == nil {
 		rootCAs = x509.NewCertPool()
 	}
 
-	if ok := rootCAs.AppendCertsFromPEM(spec.CABundle); !ok {
+	if ok := rootCAs.AppendCertsFromPEM(caBundle); !ok {
 		return nil, ErrInvalidBundle
 	}
 
 	tlsconfig := &tls.Config{
 		RootCAs: rootCAs,
 	}
-	c := cfssl.NewServerTLS(spec.URL, tlsconfig)
+	if o.clientCert != nil {
+		tlsconfig.Certificates = []tls.Certificate{*o.clientCert}
+	}
+	timeout := defaultSignTimeout
+	if spec.SignTimeout != nil {
+		timeout = spec.SignTimeout.Duration
+	}
 
-	return &CfsslProvisioner{
-		client:  c,
-		profile: spec.Profile,
-		ca:      spec.CABundle,
-	}, nil
-}
+	var provider auth.Provider
+	if o.authKey != "" {
+		// Only accept plain hex keys, auth.New would otherwise resolve
+		// "env:" and "file:" prefixes against the controller's environment.
+		if _, err := hex.DecodeString(o.authKey); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAuthKey, err)
+		}
+		standard, err := auth.New(o.authKey, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAuthKey, err)
+		}
+		provider = standard
+	}
 
-// Load returns a provisioner by NamespacedName.
-func Load(namespacedName types.NamespacedName) (*CfsslProvisioner, bool) {
-	v, ok := p.Load(namespacedName)
-	if !ok {
-		return nil, ok
+	// Acquire the transport last, as shared transports are only released
+	// by the Cache once the provisioner is replaced.
+	var transport *http.Transport
+	key := transportKey(caBundle, o.clientCert)
+	if o.transports != nil {
+		transport = o.transports.acquire(key, tlsconfig)
+	} else {
+		transport = newTransport(tlsconfig)
 	}
-	p, ok := v.(*CfsslProvisioner)
-	return p, ok
-}
+	client := &http.Client{Transport: otelhttp.NewTransport(transport)}
+	issuer := types.NamespacedName{Namespace: o.labels[1], Name: o.labels[2]}
+	endpoints := newEndpointSet(issuer, spec, func(url string) remote {
+		return newServer(url, client)
+	})
 
-// Store adds a new provisioner to the collection by NamespacedName.
-func Store(namespacedName types.NamespacedName, provisioner Provisioner) {
-	p.Store(namespacedName, provisioner)
-}
+	return &CfsslProvisioner{
+		endpoints: endpoints,
+		provider:  provider,
+		profile:   spec.Profile,
+		label:     spec.Label,
+		timeout:   timeout,
+		labels:    o.labels,
+		breaker:   o.breaker,
+		limiter:   o.limiter,
+		ca:        newCAChain(spec, caBundle),
 
-// Remove removes a provisioner from the collection
-func Remove(namespacedName types.NamespacedName) {
-	p.Delete(namespacedName)
+		verification: verificationMode(spec),
+		transportKey: key,
+		optionsKey:   o.key(),
+	}, nil
 }
 
-func (cf *CfsslProvisioner) Sign(csrpem []byte) (resp, rootCA []byte, err error) {
-	_, err = pki.DecodeX509CertificateRequestBytes(csrpem)
+// Sign signs the CSR by cfssl. The request is aborted once the sign timeout
+// of the issuer expires, which is reported as a SignError of class Timeout.
+func (cf *CfsslProvisioner) Sign(ctx context.Context, csrpem []byte, opts ...SignOption) (res *SignResult, err error) {
+	o := newSignOptions(opts)
+	profile := o.profileOr(cf.profile)
+
+	ctx, span := tracing.Tracer().Start(ctx, "CfsslProvisioner.Sign", trace.WithAttributes(
+		attribute.String("cfssl.issuer.kind", cf.labels[0]),
+		attribute.String("cfssl.issuer.namespace", cf.labels[1]),
+		attribute.String("cfssl.issuer.name", cf.labels[2]),
+		attribute.String("cfssl.profile", profile),
+		attribute.String("cfssl.label", cf.label),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	req, err := pki.DecodeX509CertificateRequestBytes(csrpem)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to validate CSR: %s", err)
+		return nil, &SignError{Class: BadRequest, Message: fmt.Sprintf("failed to validate CSR: %s", err), Err: err}
 	}
 
 	csr := certificateRequest{
-		CSR: string(csrpem),
-	}
-	if cf.profile != "" {
-		csr.Profile = cf.profile
+		CSR:     string(csrpem),
+		Profile: profile,
+		Label:   cf.label,
 	}
 
 	j, err := json.Marshal(csr)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to encode certificate request: %s", err)
+		return nil, fmt.Errorf("failed to encode certificate request: %s", err)
 	}
 
-	t := prometheus.NewTimer(signRequests.WithLabelValues(cf.profile))
-	resp, err = cf.client.Sign(j)
-	t.ObserveDuration()
+	if err := cf.limiter.acquire(); err != nil {
+		return nil, err
+	}
+	defer cf.limiter.release()
+
+	if err := cf.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cf.timeout)
+	defer cancel()
+
+	resp, endpoint, err := cf.sign(ctx, j, profile)
+	cf.breaker.record(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate by cfssl: %w", err)
+	}
+	span.SetAttributes(attribute.String("cfssl.endpoint", endpoint))
+
+	ca, err := cf.caChain(ctx)
 	if err != nil {
-		signErrors.WithLabelValues(cf.profile).Inc()
-		return nil, nil, fmt.Errorf("failed to sign certificate by cfssl: %w", err)
+		return nil, err
 	}
 
+	res, err = newSignResult(resp, ca, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return verify(cf.verification, res, req, o)
+}
+
+// maxDuration returns the MaxDuration of spec, or zero if unset.
+func maxDuration(spec api.CfsslIssuerSpec) time.Duration {
+	if spec.MaxDuration == nil {
+		return 0
+	}
+	return spec.MaxDuration.Duration
+}
+
+// newSignResult returns the result of a signed certificate, appending the
+// intermediates of the CA chain to the certificate and returning the root
+// of the chain as CA.
+func newSignResult(cert, ca []byte, endpoint string) (*SignResult, error) {
 	// Decode CA chain and append all intermediate CAs to the response to be put in tls.crt
-	caBundle, err := pki.DecodeX509CertificateChainBytes(cf.ca)
+	caBundle, err := pki.DecodeX509CertificateChainBytes(ca)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to decode CA chain: %s", err)
+		return nil, fmt.Errorf("failed to decode CA chain: %s", err)
 	}
-	respCert, err := pki.DecodeX509CertificateBytes(resp)
+	respCert, err := pki.DecodeX509CertificateBytes(cert)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to decode response cert: %s", err)
+		return nil, fmt.Errorf("failed to decode response cert: %s", err)
 	}
 
 	respChain := []*x509.Certificate{respCert}
 	respChain = append(respChain, caBundle[:len(caBundle)-1]...)
 
-	rootCA, err = pki.EncodeX509(caBundle[len(caBundle)-1])
+	rootCA, err := pki.EncodeX509(caBundle[len(caBundle)-1])
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to encode root CA: %s", err)
+		return nil, fmt.Errorf("failed to encode root CA: %s", err)
 	}
-	resp, err = pki.EncodeX509Chain(respChain)
+	resp, err := pki.EncodeX509Chain(respChain)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to encode response cert chain: %s", err)
+		return nil, fmt.Errorf("failed to encode response cert chain: %s", err)
+	}
+
+	return &SignResult{
+		Certificate: resp,
+		CA:          rootCA,
+		Endpoint:    endpoint,
+	}, nil
+}
+
+// sign sends the request for profile to the candidate endpoints in turn
+// until one of them signs it. Each endpoint is given its share of the time
+// left until ctx is done, see attemptContext. Errors which are not transient
+// are returned right away, as other endpoints would reject the request as
+// well. Once ctx is done no further endpoint is tried.
+func (cf *CfsslProvisioner) sign(ctx context.Context, req []byte, profile string) (resp []byte, url string, err error) {
+	candidates := cf.endpoints.candidates()
+	for i, e := range candidates {
+		if ctx.Err() != nil {
+			break
+		}
+
+		attemptCtx, cancel := attemptContext(ctx, len(candidates)-i)
+		start := now()
+		if cf.provider != nil {
+			resp, err = e.remote.AuthSign(attemptCtx, req, cf.provider)
+		} else {
+			resp, err = e.remote.Sign(attemptCtx, req)
+		}
+		observeSign(cf.labels, profile, now().Sub(start))
+		cancel()
+
+		if err == nil {
+			cf.endpoints.recordSuccess(e)
+			return resp, e.url, nil
+		}
+
+		serr := newSignError(err)
+		countSignError(cf.labels, profile, serr)
+		if serr.Permanent() {
+			return nil, e.url, serr
+		}
+		// A request canceled by the caller says nothing about the endpoint
+		if !errors.Is(ctx.Err(), context.Canceled) {
+			cf.endpoints.recordFailure(e, serr)
+		}
+		err = serr
+	}
+	if err == nil {
+		err = newSignError(ctx.Err())
 	}
 
-	return resp, rootCA, nil
+	return nil, "", err
+}
+
+// EndpointStatus returns the health of the configured endpoints.
+func (cf *CfsslProvisioner) EndpointStatus() []api.EndpointStatus {
+	return cf.endpoints.status()
 }
 
 // Retryable returns whether the given error from Sign is a transient
 // error (e.g. due to the network).
 func Retryable(err error) bool {
-	var cerr *cfsslerr.Error
-	if errors.As(err, &cerr) {
-		category := cfsslerr.Category((cerr.ErrorCode / 1000) * 1000)
-		reason := cfsslerr.Reason(cerr.ErrorCode % 1000)
-
-		if category == cfsslerr.APIClientError &&
-			reason == cfsslerr.ClientHTTPError &&
-			strings.Contains(cerr.Message, "Request does not match policy whitelist") {
-			return false
-		}
-	}
-	// Conservatively assume everything else is transient.
-	return true
+	return !newSignError(err).Permanent()
 }
@@ -2,22 +2,32 @@ package provisioners
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
-
-	"reflect"
 	"testing"
+	"time"
 
 	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
 	"github.com/stretchr/testify/assert"
 
 	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
 	certmanager "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+	"github.com/cloudflare/cfssl/api/signhandler"
 	cfsslerr "github.com/cloudflare/cfssl/errors"
-	"k8s.io/apimachinery/pkg/types"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var validCABundle = readOrDie("testdata/ca.pem")
@@ -56,7 +66,7 @@ func TestProvisionerCreation(t *testing.T) {
 			assert.Nil(t, err)
 			if assert.NotNil(t, pro) {
 				assert.Equal(t, pro.profile, tt.profile)
-				assert.Equal(t, pro.client.Hosts(), []string{tt.url})
+				assert.Equal(t, pro.endpoints.urls(), []string{tt.url})
 			}
 		} else {
 			assert.NotNil(t, err)
@@ -65,112 +75,364 @@ func TestProvisionerCreation(t *testing.T) {
 	}
 }
 
-func TestProvisionerFlow(t *testing.T) {
-	key := types.NamespacedName{
-		Namespace: "default",
-		Name:      "cfssl-issuer",
+func TestProvisionerCABundleOption(t *testing.T) {
+	spec := api.CfsslIssuerSpec{
+		URL: "http://test",
+	}
+
+	_, err := New(spec)
+	assert.ErrorIs(t, err, ErrInvalidBundle)
+
+	pro, err := New(spec, WithCABundle(validCABundle))
+	if assert.Nil(t, err) {
+		assert.Equal(t, validCABundle, pro.ca.get())
 	}
+}
 
-	// first create the provisioner
-	pro := newProvisioner(t, "http://test", "server")
+func TestProvisionerSigning(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
 
-	// now store it
-	Store(key, pro)
+	expectedCert, _ := os.ReadFile("testdata/client.pem")
+	expectedCA := validCABundle
 
-	// now load it
-	fetched, ok := Load(key)
-	if !ok {
-		t.Fatal("failed to retrieve provisioner")
+	csr := newCSR()
+	pro := newProvisionerWithBundle(t, mockServer.URL, "client", encodeCert(mockServer.Certificate()))
+
+	res, err := pro.Sign(context.Background(), csr.Spec.Request)
+	if err != nil {
+		t.Fatalf("failed to sign csr: %v", err)
 	}
 
-	if !reflect.DeepEqual(fetched, pro) {
-		t.Fatal("returned object is not equal to expected object")
+	if !bytes.Equal(expectedCert, res.Certificate) {
+		t.Error("returned cert does not matched expected value")
 	}
 
-	// create new spec and overwrite it
-	newPro := newProvisioner(t, "http://test2", "client")
-	Store(key, newPro)
+	if !bytes.Equal(expectedCA, res.CA) {
+		t.Error("returned ca does not matched expected value")
+	}
 
-	// now load it again
-	fetched, ok = Load(key)
-	if !ok {
-		t.Fatal("failed to retrieve provisioner")
+	if res.Endpoint != mockServer.URL {
+		t.Error("returned endpoint does not matched expected value")
 	}
+}
 
-	if !reflect.DeepEqual(fetched, newPro) {
-		t.Fatal("returned object is not equal to expected object")
+func TestProvisionerSigningWithLabel(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	spec := api.CfsslIssuerSpec{
+		URL:      mockServer.URL,
+		Label:    mock.Label,
+		CABundle: encodeCert(mockServer.Certificate()),
+		CAChain:  validCABundle,
+	}
+	pro, err := New(spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
 	}
 
-	// new remove it
-	Remove(key)
+	_, err = pro.Sign(context.Background(), newCSR().Spec.Request)
+	assert.NoError(t, err)
 
-	// now load it again
-	fetched, ok = Load(key)
-	if ok || fetched != nil {
-		t.Fatal("retrieved provisioner when it should have failed")
+	spec.Label = "unknown"
+	pro, err = New(spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
 	}
+
+	_, err = pro.Sign(context.Background(), newCSR().Spec.Request)
+	assert.Error(t, err)
 }
 
-func TestMultipleProvisioners(t *testing.T) {
-	key1 := types.NamespacedName{
-		Namespace: "default",
-		Name:      "cfssl-issuer-1",
+func TestProvisionerSigningWithProfile(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	pro := newProvisionerWithBundle(t, mockServer.URL, "client", encodeCert(mockServer.Certificate()))
+
+	_, err := pro.Sign(context.Background(), validCSR, WithProfile(""))
+	assert.NoError(t, err)
+
+	// the requested profile replaces the profile of the issuer
+	_, err = pro.Sign(context.Background(), validCSR, WithProfile(mock.UnknownProfile))
+	var serr *SignError
+	if assert.ErrorAs(t, err, &serr) {
+		assert.Equal(t, UnknownProfile, serr.Class)
 	}
+}
 
-	key2 := types.NamespacedName{
-		Namespace: "default",
-		Name:      "cfssl-issuer-2",
+func TestProvisionerSigningWithDuration(t *testing.T) {
+	// a Cfssl Server signing with the server profile of localConfig
+	cert, key := newCAKeyPair(t)
+	lp, err := NewLocal(api.CfsslIssuerSpec{
+		Mode:  api.LocalMode,
+		Local: &api.LocalSigner{Config: localConfig},
+	}, WithCAKeyPair(cert, key))
+	if err != nil {
+		t.Fatalf("failed to create local signer: %v", err)
+	}
+	handler, err := signhandler.NewHandlerFromSigner(lp.signer)
+	if err != nil {
+		t.Fatalf("failed to create sign handler: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/cfssl/sign", handler)
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+
+	pro, err := New(api.CfsslIssuerSpec{
+		URL:         srv.URL,
+		CABundle:    encodeCert(srv.Certificate()),
+		CAChain:     cert,
+		Profile:     "server",
+		MaxDuration: &meta.Duration{Duration: 12 * time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
 	}
 
-	// first create the provisioner
-	pro1 := newProvisioner(t, "http://test", "server")
-	pro2 := newProvisioner(t, "http://test2", "server")
+	// cfssl does not accept a validity, so the profile expiry applies
+	for _, d := range []time.Duration{0, 2 * time.Hour, 48 * time.Hour} {
+		res, err := pro.Sign(context.Background(), validCSR, WithDuration(d))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		leaf, err := pki.DecodeX509CertificateBytes(res.Certificate)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 24*time.Hour, leaf.NotAfter.Sub(leaf.NotBefore), "duration %s", d)
+		}
+	}
+}
 
-	// now store it
-	Store(key1, pro1)
-	Store(key2, pro2)
+func TestProvisionerSigningWithCAChain(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
 
-	// now load it
-	fetched, ok := Load(key1)
-	if !ok {
-		t.Fatal("failed to retrieve provisioner")
+	spec := api.CfsslIssuerSpec{
+		URL:      mockServer.URL,
+		Profile:  "client",
+		CABundle: encodeCert(mockServer.Certificate()),
+		CAChain:  validCABundle,
 	}
-
-	if !reflect.DeepEqual(fetched, pro1) {
-		t.Fatal("returned object is not equal to expected object")
+	pro, err := New(spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
 	}
 
-	fetched2, ok := Load(key2)
-	if !ok {
-		t.Fatal("failed to retrieve provisioner")
+	res, err := pro.Sign(context.Background(), validCSR)
+	if err != nil {
+		t.Fatalf("failed to sign csr: %v", err)
 	}
 
-	if !reflect.DeepEqual(fetched2, pro2) {
-		t.Fatal("returned object is not equal to expected object")
+	if !bytes.Equal(validCABundle, res.CA) {
+		t.Error("returned ca does not match the configured CA chain")
 	}
 }
 
-func TestProvisionerSigning(t *testing.T) {
+func TestProvisionerAuthSigning(t *testing.T) {
 	mockServer := mock.New()
 	defer mockServer.Close()
 
 	expectedCert, _ := os.ReadFile("testdata/client.pem")
-	expectedCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mockServer.Certificate().Raw})
 
-	csr := newCSR()
-	pro := newProvisionerWithBundle(t, mockServer.URL, "client", encodeCert(mockServer.Certificate()))
+	tests := []struct {
+		desc       string
+		key        string
+		shouldInit bool
+		shouldSign bool
+	}{
+		{
+			desc:       "valid key",
+			key:        mock.AuthKey,
+			shouldInit: true,
+			shouldSign: true,
+		},
+		{
+			desc:       "wrong key",
+			key:        "00112233445566778899aabbccddeeff",
+			shouldInit: true,
+			shouldSign: false,
+		},
+		{
+			desc:       "non hex key",
+			key:        "env:CFSSL_AUTH_KEY",
+			shouldInit: false,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			spec := api.CfsslIssuerSpec{
+				URL:      mockServer.URL,
+				Profile:  "client",
+				CABundle: encodeCert(mockServer.Certificate()),
+				CAChain:  validCABundle,
+			}
+
+			pro, err := New(spec, WithAuthKey(tc.key))
+			if !tc.shouldInit {
+				assert.ErrorIs(t, err, ErrInvalidAuthKey)
+				return
+			}
+			if !assert.Nil(t, err) {
+				return
+			}
 
-	cert, ca, err := pro.Sign(csr.Spec.Request)
+			res, err := pro.Sign(context.Background(), newCSR().Spec.Request)
+			if tc.shouldSign {
+				if assert.Nil(t, err) {
+					assert.Equal(t, expectedCert, res.Certificate)
+				}
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+func TestProvisionerClientCertificate(t *testing.T) {
+	clientCert, clientCA := newClientCertificate(t)
+	mockServer := mock.NewWithClientAuth(clientCA)
+	defer mockServer.Close()
+
+	spec := api.CfsslIssuerSpec{
+		URL:      mockServer.URL,
+		Profile:  "client",
+		CABundle: encodeCert(mockServer.Certificate()),
+		CAChain:  validCABundle,
+	}
+
+	pro, err := New(spec)
 	if err != nil {
-		t.Fatalf("failed to sign csr: %v", err)
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	if _, err = pro.Sign(context.Background(), newCSR().Spec.Request); err == nil {
+		t.Error("expected signing without client certificate to fail")
 	}
 
-	if !bytes.Equal(expectedCert, cert) {
-		t.Error("returned cert does not matched expected value")
+	pro, err = New(spec, WithClientCertificate(clientCert))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
 	}
+	if _, err = pro.Sign(context.Background(), newCSR().Spec.Request); err != nil {
+		t.Errorf("failed to sign csr with client certificate: %v", err)
+	}
+}
 
-	if !bytes.Equal(expectedCA, ca) {
-		t.Error("returned ca does not matched expected value")
+func TestSignErrors(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	tests := []struct {
+		desc      string
+		url       string
+		profile   string
+		label     string
+		authKey   string
+		timeout   time.Duration
+		csr       []byte
+		class     ErrorClass
+		code      int
+		permanent bool
+	}{
+		{
+			desc:      "bad csr",
+			url:       mockServer.URL,
+			csr:       []byte("this-isnt-a-csr"),
+			class:     BadRequest,
+			permanent: true,
+		},
+		{
+			desc:      "policy violation",
+			url:       mockServer.URL,
+			profile:   mock.PolicyViolationProfile,
+			class:     PolicyViolation,
+			code:      5300,
+			permanent: true,
+		},
+		{
+			desc:      "unknown profile",
+			url:       mockServer.URL,
+			profile:   mock.UnknownProfile,
+			class:     UnknownProfile,
+			code:      5400,
+			permanent: true,
+		},
+		{
+			// multirootca rejects labels without a signing key
+			desc:      "unknown label",
+			url:       mockServer.URL,
+			label:     "unknown",
+			class:     UnknownProfile,
+			code:      1,
+			permanent: true,
+		},
+		{
+			desc:      "authentication failure",
+			url:       mockServer.URL,
+			authKey:   "00112233445566778899aabbccddeeff",
+			class:     AuthenticationFailure,
+			code:      400,
+			permanent: true,
+		},
+		{
+			desc:    "server error",
+			url:     mockServer.URL,
+			profile: mock.ServerErrorProfile,
+			class:   ServerError,
+			code:    2500,
+		},
+		{
+			desc:  "unreachable",
+			url:   "https://127.0.0.1:1",
+			class: Unreachable,
+		},
+		{
+			desc:    "timeout",
+			url:     mockServer.URL,
+			profile: mock.SlowProfile,
+			timeout: 100 * time.Millisecond,
+			class:   Timeout,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var opts []Option
+			if tc.authKey != "" {
+				opts = append(opts, WithAuthKey(tc.authKey))
+			}
+			spec := api.CfsslIssuerSpec{
+				URL:      tc.url,
+				Profile:  tc.profile,
+				Label:    tc.label,
+				CABundle: encodeCert(mockServer.Certificate()),
+			}
+			if tc.timeout != 0 {
+				spec.SignTimeout = &meta.Duration{Duration: tc.timeout}
+			}
+			pro, err := New(spec, opts...)
+			if err != nil {
+				t.Fatalf("failed to create provisioner: %v", err)
+			}
+
+			csr := tc.csr
+			if csr == nil {
+				csr = validCSR
+			}
+			_, err = pro.Sign(context.Background(), csr)
+
+			var serr *SignError
+			if !assert.True(t, errors.As(err, &serr), "expected a SignError, got %v", err) {
+				return
+			}
+			assert.Equal(t, tc.class, serr.Class)
+			assert.Equal(t, tc.code, serr.Code)
+			assert.Equal(t, tc.permanent, serr.Permanent())
+			assert.Equal(t, !tc.permanent, Retryable(err))
+			if tc.code != 0 {
+				assert.Contains(t, err.Error(), fmt.Sprintf("cfssl error %d", tc.code))
+			}
+		})
 	}
 }
 
@@ -196,6 +458,17 @@ func TestRetryable(t *testing.T) {
 				errors.New("Request does not match policy whitelist")),
 			expected: false,
 		},
+		{
+			desc: "cfssl error response",
+			err: cfsslerr.Wrap(cfsslerr.APIClientError, cfsslerr.ClientHTTPError,
+				errors.New(`{"success":false,"result":null,"errors":[{"code":9002,"message":"Unable to decode CSR"}],"messages":[]}`)),
+			expected: false,
+		},
+		{
+			desc:     "failed round trip",
+			err:      cfsslerr.Wrap(cfsslerr.APIClientError, cfsslerr.ClientHTTPError, errors.New("failed POST to https://cfssl: EOF")),
+			expected: true,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.desc, func(t *testing.T) {
@@ -218,6 +491,7 @@ func newProvisionerWithBundle(t *testing.T, url, profile string, bundle []byte)
 		URL:      url,
 		Profile:  profile,
 		CABundle: bundle,
+		CAChain:  validCABundle,
 	}
 
 	pro, err := New(spec)
@@ -228,6 +502,40 @@ func newProvisionerWithBundle(t *testing.T, url, profile string, bundle []byte)
 	return pro
 }
 
+// newClientCertificate returns a self signed client certificate and a pool
+// trusting it.
+func newClientCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "cfssl-issuer"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
 func newCSR() *certmanager.CertificateRequest {
 	return &certmanager.CertificateRequest{
 		Spec: certmanager.CertificateRequestSpec{
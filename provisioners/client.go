@@ -0,0 +1,140 @@
+package provisioners
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/info"
+)
+
+// remote sends requests to a cfssl server. Errors are returned as
+// SignError.
+type remote interface {
+	Sign(ctx context.Context, req []byte) ([]byte, error)
+	AuthSign(ctx context.Context, req []byte, provider auth.Provider) ([]byte, error)
+	Info(ctx context.Context, req []byte) (*info.Resp, error)
+}
+
+// server is a remote talking to the cfssl API at url. Unlike the cfssl
+// client, it aborts requests when their context is done and reuses the
+// connections of the given http client.
+type server struct {
+	url    string
+	client *http.Client
+}
+
+func newServer(url string, client *http.Client) *server {
+	return &server{url: strings.TrimSuffix(url, "/"), client: client}
+}
+
+func (s *server) Sign(ctx context.Context, req []byte) ([]byte, error) {
+	return s.certificate(ctx, "sign", req)
+}
+
+func (s *server) AuthSign(ctx context.Context, req []byte, provider auth.Provider) ([]byte, error) {
+	token, err := provider.Token(req)
+	if err != nil {
+		return nil, &SignError{Class: AuthenticationFailure, Message: err.Error(), Err: err}
+	}
+
+	areq, err := json.Marshal(auth.AuthenticatedRequest{
+		Timestamp: now().Unix(),
+		Token:     token,
+		Request:   req,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode authenticated request: %s", err)
+	}
+
+	return s.certificate(ctx, "authsign", areq)
+}
+
+func (s *server) Info(ctx context.Context, req []byte) (*info.Resp, error) {
+	result, err := s.post(ctx, "info", req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &info.Resp{}
+	if err := json.Unmarshal(result, resp); err != nil {
+		return nil, s.malformed(err)
+	}
+	return resp, nil
+}
+
+// certificate posts req to endpoint and returns the certificate of the
+// result.
+func (s *server) certificate(ctx context.Context, endpoint string, req []byte) ([]byte, error) {
+	result, err := s.post(ctx, endpoint, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.Unmarshal(result, &resp); err != nil {
+		return nil, s.malformed(err)
+	}
+	if resp.Certificate == "" {
+		return nil, s.malformed(errors.New("response doesn't contain a certificate"))
+	}
+	return []byte(resp.Certificate), nil
+}
+
+// post sends req to the cfssl API endpoint and returns the result of a
+// successful response.
+func (s *server) post(ctx context.Context, endpoint string, req []byte) (json.RawMessage, error) {
+	url := fmt.Sprintf("%s/api/v1/cfssl/%s", s.url, endpoint)
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request to %s: %s", url, err)
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	hresp, err := s.client.Do(hreq)
+	if err != nil {
+		return nil, transportError(ctx, url, err)
+	}
+	defer hresp.Body.Close()
+
+	body, err := io.ReadAll(hresp.Body)
+	if err != nil {
+		return nil, transportError(ctx, url, err)
+	}
+
+	var resp struct {
+		Success bool            `json:"success"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if json.Unmarshal(body, &resp) != nil || !resp.Success || hresp.StatusCode != http.StatusOK {
+		serr := &SignError{Class: ServerError, Message: strings.TrimSpace(string(body))}
+		if serr.Message == "" {
+			serr.Message = hresp.Status
+		}
+		parseResponseError(serr, body)
+		return nil, serr
+	}
+
+	return resp.Result, nil
+}
+
+func (s *server) malformed(err error) error {
+	return &SignError{Class: ServerError, Message: fmt.Sprintf("malformed response from %s: %s", s.url, err), Err: err}
+}
+
+// transportError classifies a failed round trip to cfssl. Requests aborted
+// by the deadline of ctx are reported as Timeout.
+func transportError(ctx context.Context, url string, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &SignError{Class: Timeout, Message: fmt.Sprintf("request to %s timed out", url), Err: err}
+	}
+	return &SignError{Class: Unreachable, Message: fmt.Sprintf("failed POST to %s: %v", url, err), Err: err}
+}
@@ -0,0 +1,191 @@
+package provisioners
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultCooldown         = 30 * time.Second
+)
+
+var (
+	// health tracks endpoint health by issuer and url, so that it survives
+	// provisioners being rebuilt while each issuer applies its own failure
+	// threshold and cooldown.
+	health = new(sync.Map)
+
+	now = time.Now
+)
+
+// healthKey identifies the health of an endpoint of an issuer.
+type healthKey struct {
+	issuer types.NamespacedName
+	url    string
+}
+
+type endpointHealth struct {
+	mu            sync.Mutex
+	failures      int32
+	lastError     string
+	lastFailure   time.Time
+	cooldownUntil time.Time
+}
+
+type endpoint struct {
+	url    string
+	remote remote
+	health *endpointHealth
+}
+
+// endpointSet selects the endpoints a request is sent to.
+type endpointSet struct {
+	endpoints        []*endpoint
+	strategy         api.EndpointStrategy
+	failureThreshold int32
+	cooldown         time.Duration
+	next             uint32
+}
+
+// attemptContext bounds a request to the first of n remaining endpoints to
+// an even share of the time left until the deadline of ctx, so that an
+// endpoint which hangs leaves time to try the others.
+func attemptContext(ctx context.Context, n int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || n <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(n))
+}
+
+func newEndpointSet(issuer types.NamespacedName, spec api.CfsslIssuerSpec, newRemote func(url string) remote) *endpointSet {
+	set := &endpointSet{
+		strategy:         spec.Strategy,
+		failureThreshold: spec.FailureThreshold,
+		cooldown:         defaultCooldown,
+	}
+	if set.failureThreshold <= 0 {
+		set.failureThreshold = defaultFailureThreshold
+	}
+	if spec.Cooldown != nil {
+		set.cooldown = spec.Cooldown.Duration
+	}
+
+	for _, url := range endpointURLs(spec) {
+		h, _ := health.LoadOrStore(healthKey{issuer: issuer, url: url}, &endpointHealth{})
+		set.endpoints = append(set.endpoints, &endpoint{
+			url:    url,
+			remote: newRemote(url),
+			health: h.(*endpointHealth),
+		})
+	}
+
+	return set
+}
+
+// RemoveEndpointHealth removes the health of the endpoints of the issuer.
+func RemoveEndpointHealth(issuer types.NamespacedName) {
+	health.Range(func(key, _ interface{}) bool {
+		if key.(healthKey).issuer == issuer {
+			health.Delete(key)
+		}
+		return true
+	})
+}
+
+// endpointURLs returns the urls configured by spec, URL first.
+func endpointURLs(spec api.CfsslIssuerSpec) []string {
+	var urls []string
+	if spec.URL != "" {
+		urls = append(urls, spec.URL)
+	}
+	return append(urls, spec.URLs...)
+}
+
+// candidates returns the endpoints to try for a request in order. Endpoints
+// cooling down are skipped unless no other endpoint is left.
+func (s *endpointSet) candidates() []*endpoint {
+	start := 0
+	if s.strategy == api.RoundRobin && len(s.endpoints) > 0 {
+		start = int((atomic.AddUint32(&s.next, 1) - 1) % uint32(len(s.endpoints)))
+	}
+
+	ordered := make([]*endpoint, 0, len(s.endpoints))
+	ordered = append(ordered, s.endpoints[start:]...)
+	ordered = append(ordered, s.endpoints[:start]...)
+
+	var healthy []*endpoint
+	for _, e := range ordered {
+		if e.healthy() {
+			healthy = append(healthy, e)
+		}
+	}
+	if len(healthy) == 0 {
+		return ordered
+	}
+
+	return healthy
+}
+
+func (s *endpointSet) urls() []string {
+	urls := make([]string, 0, len(s.endpoints))
+	for _, e := range s.endpoints {
+		urls = append(urls, e.url)
+	}
+	return urls
+}
+
+func (s *endpointSet) status() []api.EndpointStatus {
+	status := make([]api.EndpointStatus, 0, len(s.endpoints))
+	for _, e := range s.endpoints {
+		e.health.mu.Lock()
+		st := api.EndpointStatus{
+			URL:                 e.url,
+			Healthy:             !now().Before(e.health.cooldownUntil),
+			ConsecutiveFailures: e.health.failures,
+			LastError:           e.health.lastError,
+		}
+		if !e.health.lastFailure.IsZero() {
+			t := meta.NewTime(e.health.lastFailure)
+			st.LastFailureTime = &t
+		}
+		e.health.mu.Unlock()
+
+		status = append(status, st)
+	}
+	return status
+}
+
+func (s *endpointSet) recordSuccess(e *endpoint) {
+	e.health.mu.Lock()
+	defer e.health.mu.Unlock()
+
+	e.health.failures = 0
+	e.health.cooldownUntil = time.Time{}
+}
+
+func (s *endpointSet) recordFailure(e *endpoint, err error) {
+	e.health.mu.Lock()
+	defer e.health.mu.Unlock()
+
+	e.health.failures++
+	e.health.lastError = err.Error()
+	e.health.lastFailure = now()
+	if e.health.failures >= s.failureThreshold {
+		e.health.cooldownUntil = e.health.lastFailure.Add(s.cooldown)
+	}
+}
+
+func (e *endpoint) healthy() bool {
+	e.health.mu.Lock()
+	defer e.health.mu.Unlock()
+
+	return !now().Before(e.health.cooldownUntil)
+}
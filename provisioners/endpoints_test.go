@@ -0,0 +1,184 @@
+package provisioners
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestEndpointFailover(t *testing.T) {
+	live := mock.New()
+	defer live.Close()
+	dead := mock.New()
+	dead.Close()
+
+	spec := api.CfsslIssuerSpec{
+		URLs:             []string{dead.URL, live.URL},
+		CABundle:         append(encodeCert(dead.Certificate()), encodeCert(live.Certificate())...),
+		CAChain:          validCABundle,
+		FailureThreshold: 1,
+		Cooldown:         &meta.Duration{Duration: time.Minute},
+	}
+	pro, err := New(spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	res, err := pro.Sign(context.Background(), validCSR)
+	if err != nil {
+		t.Fatalf("failed to sign csr: %v", err)
+	}
+	assert.Equal(t, live.URL, res.Endpoint)
+
+	status := pro.EndpointStatus()
+	if assert.Len(t, status, 2) {
+		assert.False(t, status[0].Healthy)
+		assert.Equal(t, int32(1), status[0].ConsecutiveFailures)
+		assert.NotEmpty(t, status[0].LastError)
+		assert.True(t, status[1].Healthy)
+	}
+
+	// the dead endpoint is skipped while cooling down
+	assert.Equal(t, []string{live.URL}, urlsOf(pro.endpoints.candidates()))
+}
+
+func TestEndpointFailoverFromHungEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+	live := mock.New()
+	defer live.Close()
+
+	spec := api.CfsslIssuerSpec{
+		URLs:        []string{hung.URL, live.URL},
+		CABundle:    append(encodeCert(hung.Certificate()), encodeCert(live.Certificate())...),
+		CAChain:     validCABundle,
+		SignTimeout: &meta.Duration{Duration: 2 * time.Second},
+	}
+	pro, err := New(spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	// the hung endpoint must not use up the sign timeout
+	res, err := pro.Sign(context.Background(), validCSR)
+	if err != nil {
+		t.Fatalf("failed to sign csr: %v", err)
+	}
+	assert.Equal(t, live.URL, res.Endpoint)
+
+	status := pro.EndpointStatus()
+	if assert.Len(t, status, 2) {
+		assert.Equal(t, int32(1), status[0].ConsecutiveFailures)
+		assert.Contains(t, status[0].LastError, string(Timeout))
+	}
+}
+
+func TestEndpointRoundRobin(t *testing.T) {
+	first := mock.New()
+	defer first.Close()
+	second := mock.New()
+	defer second.Close()
+
+	spec := api.CfsslIssuerSpec{
+		URL:      first.URL,
+		URLs:     []string{second.URL},
+		CABundle: append(encodeCert(first.Certificate()), encodeCert(second.Certificate())...),
+		CAChain:  validCABundle,
+		Strategy: api.RoundRobin,
+	}
+	pro, err := New(spec)
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	var used []string
+	for i := 0; i < 3; i++ {
+		res, err := pro.Sign(context.Background(), validCSR)
+		if err != nil {
+			t.Fatalf("failed to sign csr: %v", err)
+		}
+		used = append(used, res.Endpoint)
+	}
+	assert.Equal(t, []string{first.URL, second.URL, first.URL}, used)
+}
+
+func TestEndpointCooldown(t *testing.T) {
+	current := time.Now()
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	spec := api.CfsslIssuerSpec{
+		URLs:             []string{"https://cooldown-1", "https://cooldown-2"},
+		FailureThreshold: 2,
+		Cooldown:         &meta.Duration{Duration: time.Minute},
+	}
+	issuer := types.NamespacedName{Namespace: "default", Name: "cooldown"}
+	defer RemoveEndpointHealth(issuer)
+	set := newEndpointSet(issuer, spec, func(string) remote { return nil })
+	failing := set.endpoints[0]
+
+	set.recordFailure(failing, errors.New("connection refused"))
+	assert.Equal(t, spec.URLs, urlsOf(set.candidates()), "endpoint skipped before reaching the threshold")
+
+	set.recordFailure(failing, errors.New("connection refused"))
+	assert.Equal(t, spec.URLs[1:], urlsOf(set.candidates()), "endpoint not skipped after reaching the threshold")
+
+	current = current.Add(time.Minute)
+	assert.Equal(t, spec.URLs, urlsOf(set.candidates()), "endpoint skipped after the cooldown")
+
+	set.recordFailure(set.endpoints[1], errors.New("connection refused"))
+	set.recordFailure(set.endpoints[1], errors.New("connection refused"))
+	set.recordFailure(failing, errors.New("connection refused"))
+	assert.Equal(t, spec.URLs, urlsOf(set.candidates()), "all endpoints should be tried if none is healthy")
+
+	set.recordSuccess(failing)
+	assert.Equal(t, spec.URLs[:1], urlsOf(set.candidates()), "endpoint skipped after a successful request")
+}
+
+func TestEndpointHealthPerIssuer(t *testing.T) {
+	urls := []string{"https://shared-1", "https://shared-2"}
+	strict := types.NamespacedName{Namespace: "default", Name: "strict"}
+	lenient := types.NamespacedName{Namespace: "default", Name: "lenient"}
+	defer RemoveEndpointHealth(strict)
+	defer RemoveEndpointHealth(lenient)
+
+	newSet := func(issuer types.NamespacedName, threshold int32) *endpointSet {
+		return newEndpointSet(issuer, api.CfsslIssuerSpec{URLs: urls, FailureThreshold: threshold},
+			func(string) remote { return nil })
+	}
+	strictSet := newSet(strict, 1)
+	lenientSet := newSet(lenient, 3)
+
+	// both issuers observe the same failure of the shared endpoint
+	strictSet.recordFailure(strictSet.endpoints[0], errors.New("connection refused"))
+	lenientSet.recordFailure(lenientSet.endpoints[0], errors.New("connection refused"))
+	assert.Equal(t, urls[1:], urlsOf(strictSet.candidates()), "endpoint not skipped after reaching the threshold")
+	assert.Equal(t, urls, urlsOf(lenientSet.candidates()), "threshold of another issuer applied")
+
+	// health survives rebuilding the provisioner, but not removing the issuer
+	assert.Equal(t, urls[1:], urlsOf(newSet(strict, 1).candidates()))
+	RemoveEndpointHealth(strict)
+	assert.Equal(t, urls, urlsOf(newSet(strict, 1).candidates()))
+}
+
+func urlsOf(endpoints []*endpoint) []string {
+	urls := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		urls = append(urls, e.url)
+	}
+	return urls
+}
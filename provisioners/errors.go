@@ -0,0 +1,228 @@
+package provisioners
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	cfsslapi "github.com/cloudflare/cfssl/api"
+	cfsslerr "github.com/cloudflare/cfssl/errors"
+)
+
+// ErrorClass is the class of a failed cfssl request.
+type ErrorClass string
+
+const (
+	// BadRequest is a certificate request cfssl cannot parse or sign.
+	BadRequest ErrorClass = "BadRequest"
+	// PolicyViolation is a certificate request rejected by the signing policy.
+	PolicyViolation ErrorClass = "PolicyViolation"
+	// UnknownProfile is a profile or label unknown to cfssl.
+	UnknownProfile ErrorClass = "UnknownProfile"
+	// AuthenticationFailure is a request cfssl could not authenticate.
+	AuthenticationFailure ErrorClass = "AuthenticationFailure"
+	// ServerError is a failure of the cfssl server, e.g. its signing key
+	// being unavailable.
+	ServerError ErrorClass = "ServerError"
+	// Unreachable is a failure to contact cfssl.
+	Unreachable ErrorClass = "Unreachable"
+	// Timeout is a request aborted by its deadline, e.g. the sign timeout
+	// of the issuer.
+	Timeout ErrorClass = "Timeout"
+	// VerificationFailure is a signed certificate not matching its request,
+	// rejected under strict verification.
+	VerificationFailure ErrorClass = "VerificationFailure"
+	// Unknown is any other error.
+	Unknown ErrorClass = "Unknown"
+)
+
+const (
+	// policyWhitelistMessage is the message of cfssl's UnmatchedWhitelist error.
+	policyWhitelistMessage = "Request does not match policy whitelist"
+
+	// unknownLabelMessage is the message multirootca rejects info requests
+	// for labels without a signing key with.
+	unknownLabelMessage = "bad label"
+
+	// multirootcaCode is the error code of every error multirootca answers
+	// sign requests with, which are told apart by their message.
+	multirootcaCode = 1
+)
+
+// SignError is a failed cfssl request, classified by the returned cfssl
+// error code.
+type SignError struct {
+	// Code is the cfssl error code, or zero if cfssl did not return one.
+	Code int
+	// Class is the class of the error.
+	Class ErrorClass
+	// Message is the error message returned by cfssl.
+	Message string
+
+	Err error
+}
+
+func (e *SignError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("cfssl error %d (%s): %s", e.Code, e.Class, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Class, e.Message)
+}
+
+func (e *SignError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent returns whether retrying the request cannot succeed.
+func (e *SignError) Permanent() bool {
+	switch e.Class {
+	case BadRequest, PolicyViolation, UnknownProfile, AuthenticationFailure, VerificationFailure:
+		return true
+	default:
+		return false
+	}
+}
+
+// newSignError classifies an error returned by the cfssl client. The client
+// flattens the error responses of cfssl into its own errors, so the cfssl
+// error code is recovered from the response body where possible.
+func newSignError(err error) *SignError {
+	var serr *SignError
+	if errors.As(err, &serr) {
+		return serr
+	}
+
+	serr = &SignError{Class: Unknown, Message: err.Error(), Err: err}
+	if errors.Is(err, context.DeadlineExceeded) {
+		serr.Class = Timeout
+		return serr
+	}
+
+	var cerr *cfsslerr.Error
+	if !errors.As(err, &cerr) {
+		return serr
+	}
+	serr.Code = cerr.ErrorCode
+	serr.Message = cerr.Message
+
+	category := cfsslerr.Category((cerr.ErrorCode / 1000) * 1000)
+	reason := cfsslerr.Reason(cerr.ErrorCode % 1000)
+	if category != cfsslerr.APIClientError {
+		serr.Class = classify(cerr.ErrorCode, cerr.Message)
+		return serr
+	}
+
+	switch reason {
+	case cfsslerr.AuthenticationFailure:
+		serr.Class = AuthenticationFailure
+	case cfsslerr.IOError:
+		serr.Class = Unreachable
+	case cfsslerr.ClientHTTPError:
+		// Failed round trips and error responses are both reported as
+		// ClientHTTPError, the latter with the response body as message.
+		if strings.HasPrefix(cerr.Message, "failed POST to") {
+			serr.Class = Unreachable
+			break
+		}
+		serr.Code = 0
+		serr.Class = ServerError
+		parseResponseError(serr, []byte(cerr.Message))
+	default:
+		serr.Class = ServerError
+	}
+
+	return serr
+}
+
+// parseResponseError fills in the code and class of serr from the body of
+// a cfssl error response. Bodies without a cfssl error, e.g. from a proxy in
+// front of cfssl, leave serr unchanged.
+func parseResponseError(serr *SignError, body []byte) {
+	var resp cfsslapi.Response
+	switch {
+	case json.Unmarshal(body, &resp) == nil && len(resp.Errors) > 0:
+		serr.Code = resp.Errors[0].Code
+		serr.Message = resp.Errors[0].Message
+		serr.Class = classify(serr.Code, serr.Message)
+	case strings.Contains(string(body), policyWhitelistMessage):
+		serr.Code = int(cfsslerr.PolicyError) + int(cfsslerr.UnmatchedWhitelist)
+		serr.Class = PolicyViolation
+	}
+}
+
+// classify returns the class of a cfssl error. cfssl responds with the HTTP
+// status code as error code to requests it rejects before processing them,
+// e.g. due to an invalid token.
+func classify(code int, message string) ErrorClass {
+	switch code {
+	case http.StatusBadRequest:
+		if authenticationMessage(message) {
+			return AuthenticationFailure
+		}
+		if message == unknownLabelMessage {
+			return UnknownProfile
+		}
+		return BadRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return AuthenticationFailure
+	case multirootcaCode:
+		return classifyMultirootca(message)
+	}
+	if code >= http.StatusContinue && code < 1000 {
+		return ServerError
+	}
+
+	reason := cfsslerr.Reason(code % 1000)
+	switch cfsslerr.Category((code / 1000) * 1000) {
+	case cfsslerr.CSRError:
+		return BadRequest
+	case cfsslerr.CertificateError:
+		if reason/100*100 == cfsslerr.BadRequest {
+			return BadRequest
+		}
+		return ServerError
+	case cfsslerr.PolicyError:
+		if reason == cfsslerr.UnknownProfile {
+			return UnknownProfile
+		}
+		return PolicyViolation
+	case cfsslerr.DialError:
+		return Unreachable
+	case cfsslerr.PrivateKeyError, cfsslerr.IntermediatesError, cfsslerr.RootError,
+		cfsslerr.CTError, cfsslerr.CertStoreError:
+		return ServerError
+	default:
+		return Unknown
+	}
+}
+
+// classifyMultirootca returns the class of an error multirootca answered a
+// sign request with.
+func classifyMultirootca(message string) ErrorClass {
+	switch message {
+	case "bad request", "invalid profile":
+		// multirootca answers requests for labels without a signing key
+		// with "bad request", as well as requests its signer rejects
+		return UnknownProfile
+	case "invalid request":
+		return BadRequest
+	case "invalid token", "authorisation required", "not authorised":
+		return AuthenticationFailure
+	default:
+		return Unknown
+	}
+}
+
+// authenticationMessage returns whether message is one of the errors cfssl
+// rejects unauthenticated requests with.
+func authenticationMessage(message string) bool {
+	switch message {
+	case "invalid token", "authentication required", "no authentication provider":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,145 @@
+package provisioners
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// ThrottledRate is the reason of requests over the rate limit.
+	ThrottledRate = "rate"
+	// ThrottledConcurrency is the reason of requests over the in-flight limit.
+	ThrottledConcurrency = "concurrency"
+
+	// inFlightRetryDelay is the delay after which requests over the in-flight
+	// limit are retried.
+	inFlightRetryDelay = time.Second
+)
+
+// limiters holds the rate limiter of each issuer, so that its limits apply
+// across provisioners being rebuilt.
+var limiters = new(sync.Map)
+
+// ThrottledError is returned by Sign if the request exceeds the rate limit
+// of the issuer.
+type ThrottledError struct {
+	// Reason is the exceeded limit, ThrottledRate or ThrottledConcurrency.
+	Reason string
+	// RetryAfter is the time after which the request can be retried.
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("throttled by %s limit, retrying in %s", e.Reason, e.RetryAfter)
+}
+
+// Limiter limits the rate and concurrency of an issuer's signing requests.
+type Limiter struct {
+	mu          sync.Mutex
+	limiter     *rate.Limiter
+	maxInFlight int32
+	inFlight    int32
+
+	labels []string
+}
+
+// LimiterFor returns the rate limiter of the issuer of the given kind,
+// configured by policy.
+func LimiterFor(kind string, key types.NamespacedName, policy *api.RateLimit) *Limiter {
+	l, _ := limiters.LoadOrStore(key, &Limiter{
+		labels: []string{kind, key.Namespace, key.Name},
+	})
+	limiter := l.(*Limiter)
+	limiter.configure(policy)
+	return limiter
+}
+
+// RemoveLimiter removes the rate limiter of the issuer.
+func RemoveLimiter(key types.NamespacedName) {
+	if l, ok := limiters.LoadAndDelete(key); ok {
+		labels := l.(*Limiter).labels
+		signInFlight.DeleteLabelValues(labels...)
+		for _, reason := range []string{ThrottledRate, ThrottledConcurrency} {
+			throttledRequests.DeleteLabelValues(append(labels, reason)...)
+		}
+	}
+}
+
+func (l *Limiter) configure(policy *api.RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxInFlight = 0
+	if policy == nil {
+		l.limiter = nil
+		return
+	}
+	l.maxInFlight = policy.MaxInFlight
+
+	if policy.RequestsPerSecond <= 0 {
+		l.limiter = nil
+		return
+	}
+
+	limit := rate.Limit(policy.RequestsPerSecond)
+	burst := int(policy.Burst)
+	if burst <= 0 {
+		burst = int(policy.RequestsPerSecond)
+	}
+	if l.limiter == nil {
+		l.limiter = rate.NewLimiter(limit, burst)
+		return
+	}
+	l.limiter.SetLimitAt(now(), limit)
+	l.limiter.SetBurstAt(now(), burst)
+}
+
+// acquire returns a ThrottledError if the request exceeds the limits, and
+// otherwise counts it as in flight until release is called.
+func (l *Limiter) acquire() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxInFlight > 0 && l.inFlight >= l.maxInFlight {
+		return l.throttled(ThrottledConcurrency, inFlightRetryDelay)
+	}
+
+	if l.limiter != nil {
+		r := l.limiter.ReserveN(now(), 1)
+		if delay := r.DelayFrom(now()); delay > 0 {
+			r.CancelAt(now())
+			return l.throttled(ThrottledRate, delay)
+		}
+	}
+
+	l.inFlight++
+	signInFlight.WithLabelValues(l.labels...).Set(float64(l.inFlight))
+	return nil
+}
+
+// release marks an acquired request as done.
+func (l *Limiter) release() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	signInFlight.WithLabelValues(l.labels...).Set(float64(l.inFlight))
+}
+
+func (l *Limiter) throttled(reason string, retryAfter time.Duration) error {
+	throttledRequests.WithLabelValues(append(l.labels, reason)...).Inc()
+	return &ThrottledError{Reason: reason, RetryAfter: retryAfter}
+}
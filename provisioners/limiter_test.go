@@ -0,0 +1,81 @@
+package provisioners
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestLimiterRate(t *testing.T) {
+	current := time.Now()
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	key := types.NamespacedName{Namespace: "default", Name: "limiter-rate"}
+	defer RemoveLimiter(key)
+	l := LimiterFor("CfsslIssuer", key, &api.RateLimit{RequestsPerSecond: 2})
+
+	for i := 0; i < 2; i++ {
+		if assert.NoError(t, l.acquire(), "request within the burst throttled") {
+			l.release()
+		}
+	}
+
+	var throttled *ThrottledError
+	if assert.True(t, errors.As(l.acquire(), &throttled), "request over the rate not throttled") {
+		assert.Equal(t, ThrottledRate, throttled.Reason)
+		assert.Equal(t, 500*time.Millisecond, throttled.RetryAfter)
+	}
+
+	current = current.Add(500 * time.Millisecond)
+	assert.NoError(t, l.acquire(), "request throttled after the delay")
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "limiter-concurrency"}
+	defer RemoveLimiter(key)
+	l := LimiterFor("CfsslIssuer", key, &api.RateLimit{MaxInFlight: 1})
+
+	assert.NoError(t, l.acquire())
+
+	var throttled *ThrottledError
+	if assert.True(t, errors.As(l.acquire(), &throttled), "request over the in-flight limit not throttled") {
+		assert.Equal(t, ThrottledConcurrency, throttled.Reason)
+	}
+
+	l.release()
+	assert.NoError(t, l.acquire(), "request throttled after release")
+}
+
+func TestProvisionerLimiter(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	key := types.NamespacedName{Namespace: "default", Name: "limiter-sign"}
+	defer RemoveLimiter(key)
+	limiter := LimiterFor("CfsslIssuer", key, &api.RateLimit{RequestsPerSecond: 1})
+
+	pro, err := New(api.CfsslIssuerSpec{
+		URL:      mockServer.URL,
+		CABundle: encodeCert(mockServer.Certificate()),
+		CAChain:  validCABundle,
+	}, WithLimiter(limiter))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	_, err = pro.Sign(context.Background(), validCSR)
+	assert.NoError(t, err)
+
+	_, err = pro.Sign(context.Background(), validCSR)
+	var throttled *ThrottledError
+	assert.True(t, errors.As(err, &throttled), "expected a ThrottledError, got %v", err)
+	assert.Equal(t, int32(0), limiter.inFlight, "requests should not be in flight after Sign returns")
+}
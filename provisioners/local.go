@@ -0,0 +1,218 @@
+package provisioners
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/OpenSource-THG/cfssl-issuer/tracing"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// localEndpoint is reported as the endpoint of certificates signed by a
+// LocalProvisioner.
+const localEndpoint = "local"
+
+var (
+	ErrNoCAKeyPair      = errors.New("no CA key pair configured")
+	ErrInvalidCAKeyPair = errors.New("invalid CA key pair")
+	ErrInvalidConfig    = errors.New("invalid cfssl config")
+)
+
+// LocalProvisioner signs certificates in-process with cfssl's local signer,
+// for clusters without a Cfssl Server.
+type LocalProvisioner struct {
+	signer  *local.Signer
+	policy  *config.Signing
+	cert    *x509.Certificate
+	profile string
+	labels  []string
+	limiter *Limiter
+
+	// maxDuration caps the requested validity of certificates.
+	maxDuration time.Duration
+	// verification controls the verification of signed certificates.
+	verification api.VerificationMode
+
+	// ca is the chain of the issuing CA, which defaults to the CA
+	// certificate.
+	ca *caChain
+
+	// optionsKey identifies the options the provisioner was built from.
+	optionsKey string
+}
+
+// WithCAKeyPair configures the PEM encoded certificate and key of the CA a
+// LocalProvisioner signs with.
+func WithCAKeyPair(cert, key []byte) Option {
+	return func(o *options) {
+		o.caCert = cert
+		o.caKey = key
+	}
+}
+
+// NewLocal returns a provisioner signing with the CA configured by
+// WithCAKeyPair, according to the signing profiles of spec.Local.Config.
+func NewLocal(spec api.CfsslIssuerSpec, opts ...Option) (*LocalProvisioner, error) {
+	o := newOptions(opts)
+
+	if o.caCert == nil || o.caKey == nil {
+		return nil, ErrNoCAKeyPair
+	}
+	pair, err := tls.X509KeyPair(o.caCert, o.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCAKeyPair, err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCAKeyPair, err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported private key", ErrInvalidCAKeyPair)
+	}
+
+	var policy *config.Signing
+	if spec.Local != nil && spec.Local.Config != "" {
+		cfg, err := config.LoadConfig([]byte(spec.Local.Config))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+		}
+		policy = cfg.Signing
+	}
+	// cfssl falls back to the default profile for unknown profiles, which
+	// would silently sign with the wrong usages and expiry
+	if !knownProfile(policy, spec.Profile) {
+		return nil, fmt.Errorf("%w: unknown profile %q", ErrInvalidConfig, spec.Profile)
+	}
+	for _, rule := range spec.ProfileRules {
+		if !knownProfile(policy, rule.Profile) {
+			return nil, fmt.Errorf("%w: unknown profile %q", ErrInvalidConfig, rule.Profile)
+		}
+	}
+	for _, profile := range spec.AllowedProfileOverrides {
+		if !knownProfile(policy, profile) {
+			return nil, fmt.Errorf("%w: unknown profile %q", ErrInvalidConfig, profile)
+		}
+	}
+
+	s, err := local.NewSigner(key, cert, signer.DefaultSigAlgo(key), policy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+	}
+
+	return &LocalProvisioner{
+		signer:  s,
+		policy:  policy,
+		cert:    cert,
+		profile: spec.Profile,
+		labels:  o.labels,
+		limiter: o.limiter,
+		ca:      newCAChain(spec, o.caCert),
+
+		maxDuration:  maxDuration(spec),
+		verification: verificationMode(spec),
+		optionsKey:   o.key(),
+	}, nil
+}
+
+// Sign signs the CSR with the CA of the provisioner.
+func (lp *LocalProvisioner) Sign(ctx context.Context, csrpem []byte, opts ...SignOption) (res *SignResult, err error) {
+	o := newSignOptions(opts)
+	profile := o.profileOr(lp.profile)
+
+	_, span := tracing.Tracer().Start(ctx, "LocalProvisioner.Sign", trace.WithAttributes(
+		attribute.String("cfssl.issuer.kind", lp.labels[0]),
+		attribute.String("cfssl.issuer.namespace", lp.labels[1]),
+		attribute.String("cfssl.issuer.name", lp.labels[2]),
+		attribute.String("cfssl.profile", profile),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if !knownProfile(lp.policy, profile) {
+		return nil, &SignError{Class: UnknownProfile, Message: fmt.Sprintf("unknown profile %q", profile)}
+	}
+
+	req, err := pki.DecodeX509CertificateRequestBytes(csrpem)
+	if err != nil {
+		return nil, &SignError{Class: BadRequest, Message: fmt.Sprintf("failed to validate CSR: %s", err), Err: err}
+	}
+
+	if err := lp.limiter.acquire(); err != nil {
+		return nil, err
+	}
+	defer lp.limiter.release()
+
+	notBefore, notAfter := o.validity(lp.maxDuration)
+
+	start := now()
+	cert, err := lp.signer.Sign(signer.SignRequest{
+		Request:   string(csrpem),
+		Profile:   profile,
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	})
+	observeSign(lp.labels, profile, now().Sub(start))
+	if err != nil {
+		serr := newSignError(err)
+		countSignError(lp.labels, profile, serr)
+		return nil, fmt.Errorf("failed to sign certificate: %w", serr)
+	}
+
+	res, err = newSignResult(cert, lp.ca.get(), localEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return verify(lp.verification, res, req, o)
+}
+
+// knownProfile returns whether policy defines profile. The empty profile
+// selects the default profile, which is always defined.
+func knownProfile(policy *config.Signing, profile string) bool {
+	return profile == "" || (policy != nil && policy.Profiles[profile] != nil)
+}
+
+// Probe checks that the CA certificate is valid.
+func (lp *LocalProvisioner) Probe(context.Context) error {
+	t := now()
+	switch {
+	case t.Before(lp.cert.NotBefore):
+		return &ProbeError{Err: fmt.Errorf("CA certificate is not valid before %s", lp.cert.NotBefore)}
+	case t.After(lp.cert.NotAfter):
+		return &ProbeError{Err: fmt.Errorf("CA certificate expired at %s", lp.cert.NotAfter)}
+	default:
+		return nil
+	}
+}
+
+// RefreshCAChain is a no-op, as the CA chain of a LocalProvisioner is not
+// discovered.
+func (lp *LocalProvisioner) RefreshCAChain(context.Context) error {
+	return nil
+}
+
+// CAChainRefreshInterval returns zero, as the CA chain of a
+// LocalProvisioner is not discovered.
+func (lp *LocalProvisioner) CAChainRefreshInterval() time.Duration {
+	return 0
+}
+
+// CAStatus describes the issuing CA.
+func (lp *LocalProvisioner) CAStatus() *api.CAStatus {
+	return lp.ca.status()
+}
+
+// EndpointStatus returns nil, as a LocalProvisioner has no endpoints.
+func (lp *LocalProvisioner) EndpointStatus() []api.EndpointStatus {
+	return nil
+}
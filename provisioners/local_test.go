@@ -0,0 +1,262 @@
+package provisioners
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const localConfig = `{
+	"signing": {
+		"default": {"expiry": "1h", "usages": ["signing", "client auth"]},
+		"profiles": {
+			"server": {"expiry": "24h", "usages": ["signing", "key encipherment", "server auth"]}
+		}
+	}
+}`
+
+func TestNewLocal(t *testing.T) {
+	cert, key := newCAKeyPair(t)
+
+	tests := []struct {
+		name    string
+		spec    api.CfsslIssuerSpec
+		opts    []Option
+		wantErr error
+	}{
+		{
+			name:    "no key pair",
+			spec:    api.CfsslIssuerSpec{Mode: api.LocalMode},
+			wantErr: ErrNoCAKeyPair,
+		},
+		{
+			name:    "mismatched key pair",
+			spec:    api.CfsslIssuerSpec{Mode: api.LocalMode},
+			opts:    []Option{WithCAKeyPair(validCABundle, key)},
+			wantErr: ErrInvalidCAKeyPair,
+		},
+		{
+			name: "invalid config",
+			spec: api.CfsslIssuerSpec{
+				Mode:  api.LocalMode,
+				Local: &api.LocalSigner{Config: `{"signing": `},
+			},
+			opts:    []Option{WithCAKeyPair(cert, key)},
+			wantErr: ErrInvalidConfig,
+		},
+		{
+			name: "unknown profile",
+			spec: api.CfsslIssuerSpec{
+				Mode:    api.LocalMode,
+				Local:   &api.LocalSigner{Config: localConfig},
+				Profile: "client",
+			},
+			opts:    []Option{WithCAKeyPair(cert, key)},
+			wantErr: ErrInvalidConfig,
+		},
+		{
+			name: "unknown rule profile",
+			spec: api.CfsslIssuerSpec{
+				Mode:         api.LocalMode,
+				Local:        &api.LocalSigner{Config: localConfig},
+				ProfileRules: []api.ProfileRule{{Profile: "client"}},
+			},
+			opts:    []Option{WithCAKeyPair(cert, key)},
+			wantErr: ErrInvalidConfig,
+		},
+		{
+			name: "unknown override profile",
+			spec: api.CfsslIssuerSpec{
+				Mode:                    api.LocalMode,
+				Local:                   &api.LocalSigner{Config: localConfig},
+				AllowedProfileOverrides: []string{"client"},
+			},
+			opts:    []Option{WithCAKeyPair(cert, key)},
+			wantErr: ErrInvalidConfig,
+		},
+		{
+			name: "default config",
+			spec: api.CfsslIssuerSpec{Mode: api.LocalMode},
+			opts: []Option{WithCAKeyPair(cert, key)},
+		},
+		{
+			name: "profile",
+			spec: api.CfsslIssuerSpec{
+				Mode:    api.LocalMode,
+				Local:   &api.LocalSigner{Config: localConfig},
+				Profile: "server",
+			},
+			opts: []Option{WithCAKeyPair(cert, key)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := NewBackend(test.spec, test.opts...)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				assert.Nil(t, p)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.IsType(t, &LocalProvisioner{}, p)
+			}
+		})
+	}
+}
+
+func TestLocalSigning(t *testing.T) {
+	cert, key := newCAKeyPair(t)
+	spec := api.CfsslIssuerSpec{
+		Mode:    api.LocalMode,
+		Local:   &api.LocalSigner{Config: localConfig},
+		Profile: "server",
+	}
+
+	pro, err := NewLocal(spec, WithCAKeyPair(cert, key))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	res, err := pro.Sign(context.Background(), validCSR)
+	if err != nil {
+		t.Fatalf("failed to sign csr: %v", err)
+	}
+
+	// The self signed CA is the root of the chain, so only the leaf is
+	// returned as certificate
+	assert.Equal(t, cert, res.CA)
+	assert.Equal(t, localEndpoint, res.Endpoint)
+
+	chain, err := pki.DecodeX509CertificateChainBytes(res.Certificate)
+	if assert.NoError(t, err) && assert.Len(t, chain, 1) {
+		ca, _ := pki.DecodeX509CertificateBytes(cert)
+		assert.NoError(t, chain[0].CheckSignatureFrom(ca))
+		assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, chain[0].ExtKeyUsage)
+		assert.Equal(t, 24*time.Hour, chain[0].NotAfter.Sub(chain[0].NotBefore))
+	}
+
+	assert.NoError(t, pro.Probe(context.Background()))
+	if status := pro.CAStatus(); assert.NotNil(t, status) {
+		assert.Equal(t, "CN=cfssl-issuer", status.Subject)
+	}
+}
+
+func TestLocalSigningWithDuration(t *testing.T) {
+	cert, key := newCAKeyPair(t)
+	spec := api.CfsslIssuerSpec{
+		Mode:        api.LocalMode,
+		Local:       &api.LocalSigner{Config: localConfig},
+		Profile:     "server",
+		MaxDuration: &meta.Duration{Duration: 12 * time.Hour},
+	}
+
+	pro, err := NewLocal(spec, WithCAKeyPair(cert, key))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		duration time.Duration
+		want     time.Duration
+	}{
+		{name: "requested", duration: 2 * time.Hour, want: 2 * time.Hour},
+		{name: "above maximum", duration: 48 * time.Hour, want: 12 * time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := pro.Sign(context.Background(), validCSR, WithDuration(test.duration))
+			if err != nil {
+				t.Fatalf("failed to sign csr: %v", err)
+			}
+
+			leaf, err := pki.DecodeX509CertificateBytes(res.Certificate)
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.want, leaf.NotAfter.Sub(leaf.NotBefore))
+			}
+		})
+	}
+}
+
+func TestLocalSigningWithProfile(t *testing.T) {
+	cert, key := newCAKeyPair(t)
+	spec := api.CfsslIssuerSpec{
+		Mode:  api.LocalMode,
+		Local: &api.LocalSigner{Config: localConfig},
+	}
+
+	pro, err := NewLocal(spec, WithCAKeyPair(cert, key))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	res, err := pro.Sign(context.Background(), validCSR, WithProfile("server"))
+	if err != nil {
+		t.Fatalf("failed to sign csr: %v", err)
+	}
+	leaf, err := pki.DecodeX509CertificateBytes(res.Certificate)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, leaf.ExtKeyUsage)
+	}
+
+	// unknown profiles are rejected rather than signed with the default one
+	_, err = pro.Sign(context.Background(), validCSR, WithProfile("client"))
+	var serr *SignError
+	if assert.ErrorAs(t, err, &serr) {
+		assert.Equal(t, UnknownProfile, serr.Class)
+	}
+	assert.False(t, Retryable(err))
+}
+
+func TestLocalSigningBadRequest(t *testing.T) {
+	cert, key := newCAKeyPair(t)
+
+	pro, err := NewLocal(api.CfsslIssuerSpec{Mode: api.LocalMode}, WithCAKeyPair(cert, key))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	_, err = pro.Sign(context.Background(), []byte("not a csr"))
+	assert.False(t, Retryable(err))
+}
+
+func TestLocalProbeExpiredCA(t *testing.T) {
+	cert, key := newCAKeyPair(t)
+
+	pro, err := NewLocal(api.CfsslIssuerSpec{Mode: api.LocalMode}, WithCAKeyPair(cert, key))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	defer func(orig func() time.Time) { now = orig }(now)
+	now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	var probeErr *ProbeError
+	if assert.ErrorAs(t, pro.Probe(context.Background()), &probeErr) {
+		assert.False(t, probeErr.Unreachable)
+	}
+}
+
+// newCAKeyPair returns the PEM encoded certificate and key of a self signed
+// CA, valid for an hour.
+func newCAKeyPair(t *testing.T) ([]byte, []byte) {
+	ca, _ := newClientCertificate(t)
+
+	der, err := x509.MarshalECPrivateKey(ca.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+
+	return encodeCert(ca.Leaf), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
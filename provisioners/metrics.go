@@ -1,6 +1,8 @@
 package provisioners
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
@@ -10,20 +12,68 @@ const (
 )
 
 var (
+	// signRequests is superseded by signDuration, which is labelled by issuer.
 	signRequests = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Help:      "duration in seconds for signing requests",
+		Help:      "duration in seconds for signing requests, deprecated in favour of sign_duration_seconds",
 		Namespace: metricsNamespace,
 		Name:      "sign_request_seconds",
 		Buckets:   []float64{0.05, 0.1, 0.5, 1.0, 5.0},
 	}, []string{"profile"})
+	// signErrors is superseded by signErrorsTotal, which is labelled by
+	// issuer and error class.
 	signErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Help:      "upstream signing errors",
+		Help:      "upstream signing errors, deprecated in favour of sign_errors_total",
 		Namespace: metricsNamespace,
 		Name:      "sign_errors",
 	}, []string{"profile"})
+	signDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Help:      "duration in seconds of signing requests to cfssl endpoints",
+		Namespace: metricsNamespace,
+		Name:      "sign_duration_seconds",
+		Buckets:   []float64{0.05, 0.1, 0.5, 1.0, 5.0},
+	}, []string{"kind", "namespace", "name", "profile"})
+	signErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "signing requests failed by cfssl endpoints, by error class",
+		Namespace: metricsNamespace,
+		Name:      "sign_errors_total",
+	}, []string{"kind", "namespace", "name", "profile", "class"})
+	circuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Help:      "circuit breaker state of issuers, 0 closed, 1 half-open, 2 open",
+		Namespace: metricsNamespace,
+		Name:      "circuit_breaker_state",
+	}, []string{"kind", "namespace", "name"})
+	throttledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "signing requests of issuers throttled by their rate limits",
+		Namespace: metricsNamespace,
+		Name:      "throttled_requests_total",
+	}, []string{"kind", "namespace", "name", "reason"})
+	signInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Help:      "signing requests of issuers in flight",
+		Namespace: metricsNamespace,
+		Name:      "sign_requests_in_flight",
+	}, []string{"kind", "namespace", "name"})
 )
 
 func init() {
 	metrics.Registry.MustRegister(signRequests)
 	metrics.Registry.MustRegister(signErrors)
+	metrics.Registry.MustRegister(signDuration)
+	metrics.Registry.MustRegister(signErrorsTotal)
+	metrics.Registry.MustRegister(circuitBreakerState)
+	metrics.Registry.MustRegister(throttledRequests)
+	metrics.Registry.MustRegister(signInFlight)
+}
+
+// observeSign records the duration of a signing request of the issuer
+// identified by labels, see WithIssuer.
+func observeSign(labels []string, profile string, elapsed time.Duration) {
+	signRequests.WithLabelValues(profile).Observe(elapsed.Seconds())
+	signDuration.WithLabelValues(append(labels, profile)...).Observe(elapsed.Seconds())
+}
+
+// countSignError records a failed signing request of the issuer identified
+// by labels, see WithIssuer.
+func countSignError(labels []string, profile string, serr *SignError) {
+	signErrors.WithLabelValues(profile).Inc()
+	signErrorsTotal.WithLabelValues(append(labels, profile, string(serr.Class))...).Inc()
 }
@@ -0,0 +1,38 @@
+package provisioners
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSignMetrics(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	key := types.NamespacedName{Namespace: "default", Name: "metrics"}
+	pro, err := New(api.CfsslIssuerSpec{
+		URL:      mockServer.URL,
+		Profile:  mock.PolicyViolationProfile,
+		CABundle: encodeCert(mockServer.Certificate()),
+	}, WithIssuer("CfsslIssuer", key))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	legacy := testutil.ToFloat64(signErrors.WithLabelValues(mock.PolicyViolationProfile))
+
+	_, err = pro.Sign(context.Background(), validCSR)
+	assert.Error(t, err)
+
+	errs := signErrorsTotal.WithLabelValues("CfsslIssuer", "default", "metrics", mock.PolicyViolationProfile, string(PolicyViolation))
+	assert.Equal(t, 1.0, testutil.ToFloat64(errs))
+	assert.Equal(t, legacy+1, testutil.ToFloat64(signErrors.WithLabelValues(mock.PolicyViolationProfile)),
+		"deprecated metric should still be counted")
+}
@@ -1,22 +1,132 @@
 package mock
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"time"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/auth"
+	cfsslerr "github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/info"
+)
+
+const (
+	// AuthKey is the hex encoded key accepted by the authsign endpoint.
+	AuthKey = "0123456789abcdef0123456789abcdef"
+
+	// UnknownProfile is a profile rejected by the info and sign endpoints.
+	UnknownProfile = "unknown"
+
+	// PolicyViolationProfile is a profile whose sign requests violate the
+	// signing policy.
+	PolicyViolationProfile = "policy-violation"
+
+	// ServerErrorProfile is a profile whose signing key is unavailable.
+	ServerErrorProfile = "server-error"
+
+	// SlowProfile is a profile whose sign requests are not answered until
+	// the client gives up.
+	SlowProfile = "slow"
+
+	// Label is the multirootca label of the signing key served besides the
+	// default one. Requests for other labels are rejected like multirootca
+	// does.
+	Label = "intermediate"
 )
 
 func New() *httptest.Server {
+	return httptest.NewTLSServer(newMux())
+}
+
+// NewWithClientAuth returns a server which requires TLS client certificates
+// signed by one of the given CAs.
+func NewWithClientAuth(clientCAs *x509.CertPool) *httptest.Server {
+	srv := httptest.NewUnstartedServer(newMux())
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	return srv
+}
+
+func newMux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/v1/cfssl/sign", mockSign)
-	return httptest.NewTLSServer(mux)
+	mux.HandleFunc("/api/v1/cfssl/authsign", mockAuthSign)
+	mux.HandleFunc("/api/v1/cfssl/info", mockInfo)
+	return mux
+}
+
+// signRequest is the part of a cfssl sign request the mock evaluates.
+type signRequest struct {
+	Label   string `json:"label"`
+	Profile string `json:"profile"`
 }
 
 func mockSign(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		api.HandleError(w, cfsslerr.NewBadRequest(err))
+		return
+	}
+
+	sign(w, r, body)
+}
+
+func mockAuthSign(w http.ResponseWriter, r *http.Request) {
+	var req auth.AuthenticatedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.HandleError(w, cfsslerr.NewBadRequestString("Unable to parse authenticated sign request"))
+		return
+	}
+
+	provider, _ := auth.New(AuthKey, nil)
+	if !provider.Verify(&req) {
+		api.HandleError(w, cfsslerr.NewBadRequestString("invalid token"))
+		return
+	}
+
+	sign(w, r, req.Request)
+}
+
+func sign(w http.ResponseWriter, r *http.Request, body []byte) {
+	var req signRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		api.HandleError(w, cfsslerr.NewBadRequestString("Unable to parse sign request"))
+		return
+	}
+	if !knownLabel(req.Label) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(api.NewErrorResponse("bad request", 1))
+		return
+	}
+
+	switch req.Profile {
+	case UnknownProfile:
+		api.HandleError(w, cfsslerr.New(cfsslerr.PolicyError, cfsslerr.UnknownProfile))
+		return
+	case PolicyViolationProfile:
+		api.HandleError(w, cfsslerr.New(cfsslerr.PolicyError, cfsslerr.InvalidRequest))
+		return
+	case ServerErrorProfile:
+		api.HandleError(w, cfsslerr.New(cfsslerr.PrivateKeyError, cfsslerr.Unavailable))
+		return
+	case SlowProfile:
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Minute):
+		}
+		return
+	}
+
 	cert, err := os.ReadFile("testdata/client.pem")
 	if err != nil {
 		http.Error(w, fmt.Errorf("fail to load cert: %v", err).Error(), http.StatusInternalServerError)
@@ -32,3 +142,41 @@ func mockSign(w http.ResponseWriter, r *http.Request) {
 
 	_ = json.NewEncoder(w).Encode(resp)
 }
+
+func mockInfo(w http.ResponseWriter, r *http.Request) {
+	var req info.Req
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.HandleError(w, cfsslerr.NewBadRequestString("Unable to parse info request"))
+		return
+	}
+	if !knownLabel(req.Label) {
+		api.HandleError(w, cfsslerr.NewBadRequestString("bad label"))
+		return
+	}
+	if req.Profile == UnknownProfile {
+		api.HandleError(w, cfsslerr.New(cfsslerr.PolicyError, cfsslerr.UnknownProfile))
+		return
+	}
+
+	cert, err := os.ReadFile("testdata/ca.pem")
+	if err != nil {
+		http.Error(w, fmt.Errorf("fail to load ca: %v", err).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := api.Response{
+		Success: true,
+		Result: map[string]interface{}{
+			"certificate": string(cert),
+			"usages":      []string{"signing", "key encipherment", "client auth"},
+			"expiry":      "8760h",
+		},
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// knownLabel returns whether the mock serves the signing key of label.
+func knownLabel(label string) bool {
+	return label == "" || label == Label
+}
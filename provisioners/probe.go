@@ -0,0 +1,81 @@
+package provisioners
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloudflare/cfssl/info"
+)
+
+// ProbeError is returned by Probe if no endpoint passed the health check.
+type ProbeError struct {
+	// Unreachable is true if no endpoint could be contacted, as opposed to
+	// endpoints rejecting the probe, e.g. due to an unknown profile.
+	Unreachable bool
+
+	Err error
+}
+
+func (e *ProbeError) Error() string {
+	if e.Unreachable {
+		return fmt.Sprintf("cfssl is unreachable: %v", e.Err)
+	}
+	return fmt.Sprintf("cfssl rejected the health check: %v", e.Err)
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// Probe checks that the cfssl endpoints are reachable and serve the
+// configured profile by requesting their info. The health of every
+// endpoint is updated with the result. It succeeds if at least one endpoint
+// passed the check. Each endpoint is given the sign timeout to answer.
+func (cf *CfsslProvisioner) Probe(ctx context.Context) error {
+	req, err := json.Marshal(info.Req{Label: cf.label, Profile: cf.profile})
+	if err != nil {
+		return fmt.Errorf("failed to encode info request: %s", err)
+	}
+
+	probeErr := &ProbeError{Unreachable: true}
+	healthy := false
+	for _, e := range cf.endpoints.endpoints {
+		if err := cf.probe(ctx, e, req); err != nil {
+			probeErr.Err = err
+			if unreachable(err) {
+				cf.endpoints.recordFailure(e, err)
+			} else {
+				probeErr.Unreachable = false
+			}
+			continue
+		}
+
+		cf.endpoints.recordSuccess(e)
+		healthy = true
+	}
+
+	if healthy {
+		return nil
+	}
+	return probeErr
+}
+
+func (cf *CfsslProvisioner) probe(ctx context.Context, e *endpoint, req []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, cf.timeout)
+	defer cancel()
+
+	_, err := e.remote.Info(ctx, req)
+	return err
+}
+
+// unreachable returns whether err was caused by failing to contact cfssl
+// rather than by cfssl rejecting the request.
+func unreachable(err error) bool {
+	switch newSignError(err).Class {
+	case Unreachable, Timeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,84 @@
+package provisioners
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+)
+
+func TestProbe(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	tests := []struct {
+		name        string
+		urls        []string
+		profile     string
+		label       string
+		wantErr     bool
+		unreachable bool
+	}{
+		{
+			name: "healthy",
+			urls: []string{mockServer.URL},
+		},
+		{
+			name: "one endpoint healthy",
+			urls: []string{"https://127.0.0.1:1", mockServer.URL},
+		},
+		{
+			name:        "unreachable",
+			urls:        []string{"https://127.0.0.1:1"},
+			wantErr:     true,
+			unreachable: true,
+		},
+		{
+			name:    "unknown profile",
+			urls:    []string{mockServer.URL},
+			profile: mock.UnknownProfile,
+			wantErr: true,
+		},
+		{
+			name:  "label",
+			urls:  []string{mockServer.URL},
+			label: mock.Label,
+		},
+		{
+			name:    "unknown label",
+			urls:    []string{mockServer.URL},
+			label:   "unknown",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := api.CfsslIssuerSpec{
+				URLs:     tt.urls,
+				Profile:  tt.profile,
+				Label:    tt.label,
+				CABundle: encodeCert(mockServer.Certificate()),
+			}
+			pro, err := New(spec)
+			if err != nil {
+				t.Fatalf("failed to create provisioner: %v", err)
+			}
+
+			err = pro.Probe(context.Background())
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+
+			var probeErr *ProbeError
+			if assert.True(t, errors.As(err, &probeErr), "expected a ProbeError, got %v", err) {
+				assert.Equal(t, tt.unreachable, probeErr.Unreachable)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package provisioners
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+)
+
+func TestSignTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	pro, err := New(api.CfsslIssuerSpec{
+		URL:      mockServer.URL,
+		CABundle: encodeCert(mockServer.Certificate()),
+		CAChain:  validCABundle,
+	})
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	_, err = pro.Sign(context.Background(), validCSR)
+	assert.NoError(t, err)
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	request, sign := spans[0], spans[1]
+	assert.Equal(t, "CfsslProvisioner.Sign", sign.Name())
+	assert.Equal(t, sign.SpanContext().SpanID(), request.Parent().SpanID(),
+		"cfssl request should be traced as child of the sign span")
+	assert.Equal(t, sign.SpanContext().TraceID(), request.SpanContext().TraceID())
+}
@@ -0,0 +1,178 @@
+package provisioners
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	cmutil "github.com/cert-manager/cert-manager/pkg/api/util"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+)
+
+// verificationMode returns the Verification of spec, defaulting to strict.
+func verificationMode(spec api.CfsslIssuerSpec) api.VerificationMode {
+	if spec.Verification == "" {
+		return api.StrictVerification
+	}
+	return spec.Verification
+}
+
+// verify checks the certificate of res against the csr, the requested
+// usages and the CA chain according to mode. Under warn, a certificate
+// failing verification is returned with the failure as its VerifyErr, while
+// under strict it is rejected with a SignError of class VerificationFailure.
+func verify(mode api.VerificationMode, res *SignResult, csr *x509.CertificateRequest, o *signOptions) (*SignResult, error) {
+	if mode == api.NoVerification {
+		return res, nil
+	}
+
+	err := verifyCertificate(res, csr, o)
+	if err == nil {
+		return res, nil
+	}
+	if mode == api.StrictVerification {
+		return nil, &SignError{Class: VerificationFailure, Message: err.Error(), Err: err}
+	}
+	res.VerifyErr = err
+	return res, nil
+}
+
+// verifyCertificate checks that the certificate of res has the public key
+// and SANs of the csr and the requested usages, and that it chains to the
+// CA handed to cert-manager. The returned error describes every mismatch.
+func verifyCertificate(res *SignResult, csr *x509.CertificateRequest, o *signOptions) error {
+	chain, err := pki.DecodeX509CertificateChainBytes(res.Certificate)
+	if err != nil {
+		return fmt.Errorf("failed to decode certificate: %s", err)
+	}
+	cert := chain[0]
+
+	var mismatches []string
+	mismatch := func(format string, args ...interface{}) {
+		mismatches = append(mismatches, fmt.Sprintf(format, args...))
+	}
+
+	if ok, err := pki.PublicKeyMatchesCSR(cert.PublicKey, csr); err != nil || !ok {
+		mismatch("public key does not match the CSR")
+	}
+
+	for _, name := range csr.DNSNames {
+		if !containsFold(cert.DNSNames, name) {
+			mismatch("dns name %q is missing", name)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if !containsIP(cert.IPAddresses, ip) {
+			mismatch("ip address %q is missing", ip)
+		}
+	}
+	for _, uri := range csr.URIs {
+		if !containsURI(cert, uri.String()) {
+			mismatch("uri %q is missing", uri)
+		}
+	}
+	for _, email := range csr.EmailAddresses {
+		if !containsFold(cert.EmailAddresses, email) {
+			mismatch("email address %q is missing", email)
+		}
+	}
+
+	// Only explicitly requested usages are checked, as profiles commonly
+	// differ from the defaults of cert-manager
+	for _, usage := range o.usages {
+		if ku, ok := cmutil.KeyUsageType(usage); ok && cert.KeyUsage&ku == 0 {
+			mismatch("key usage %q is missing", usage)
+		}
+		if eku, ok := cmutil.ExtKeyUsageType(usage); ok && !hasExtKeyUsage(cert, eku) {
+			mismatch("key usage %q is missing", usage)
+		}
+	}
+	if o.isCA && !cert.IsCA {
+		mismatch("certificate is not a CA")
+	}
+
+	if err := verifyChain(cert, chain[1:], res.CA); err != nil {
+		mismatch("certificate does not chain to the CA: %s", err)
+	}
+
+	if len(mismatches) > 0 {
+		return errors.New(strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// verifyChain checks that cert chains to the root CA through the given
+// intermediates. The chain is verified at the time closest to now at which
+// cert is valid, so that the clock of cfssl skewing from the local one, or
+// cert having expired, does not fail the check.
+func verifyChain(cert *x509.Certificate, intermediates []*x509.Certificate, rootPEM []byte) error {
+	root, err := pki.DecodeX509CertificateBytes(rootPEM)
+	if err != nil {
+		return err
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   clamp(now(), cert.NotBefore, cert.NotAfter),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	opts.Roots.AddCert(root)
+	for _, c := range intermediates {
+		opts.Intermediates.AddCert(c)
+	}
+
+	_, err = cert.Verify(opts)
+	return err
+}
+
+func clamp(t, min, max time.Time) time.Time {
+	switch {
+	case t.Before(min):
+		return min
+	case t.After(max):
+		return max
+	default:
+		return t
+	}
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, eku x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == eku || u == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+func containsURI(cert *x509.Certificate, uri string) bool {
+	for _, u := range cert.URIs {
+		if u.String() == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package provisioners
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/OpenSource-THG/cfssl-issuer/provisioners/mock"
+	"github.com/stretchr/testify/assert"
+
+	api "github.com/OpenSource-THG/cfssl-issuer/api/v1beta1"
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/cert-manager/cert-manager/pkg/util/pki"
+)
+
+func TestVerificationModes(t *testing.T) {
+	mockServer := mock.New()
+	defer mockServer.Close()
+
+	tests := []struct {
+		name         string
+		verification api.VerificationMode
+		caChain      []byte
+		wantErr      bool
+		wantWarning  bool
+	}{
+		{
+			name:         "strict",
+			verification: api.StrictVerification,
+			caChain:      validCABundle,
+		},
+		{
+			name:         "strict mismatch",
+			verification: api.StrictVerification,
+			caChain:      encodeCert(mockServer.Certificate()),
+			wantErr:      true,
+		},
+		{
+			name:         "warn mismatch",
+			verification: api.WarnVerification,
+			caChain:      encodeCert(mockServer.Certificate()),
+			wantWarning:  true,
+		},
+		{
+			// the CA chain falls back to the CA bundle of the server, which
+			// the certificate does not chain to
+			name:    "ca bundle mismatch",
+			wantErr: true,
+		},
+		{
+			name:         "off",
+			verification: api.NoVerification,
+			caChain:      encodeCert(mockServer.Certificate()),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pro, err := New(api.CfsslIssuerSpec{
+				URL:          mockServer.URL,
+				CABundle:     encodeCert(mockServer.Certificate()),
+				CAChain:      test.caChain,
+				Verification: test.verification,
+			})
+			if err != nil {
+				t.Fatalf("failed to create provisioner: %v", err)
+			}
+
+			res, err := pro.Sign(context.Background(), validCSR)
+			if test.wantErr {
+				var serr *SignError
+				if assert.ErrorAs(t, err, &serr) {
+					assert.Equal(t, VerificationFailure, serr.Class)
+					assert.Contains(t, serr.Message, "certificate does not chain to the CA")
+				}
+				assert.False(t, Retryable(err))
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.wantWarning, res.VerifyErr != nil)
+			}
+		})
+	}
+}
+
+func TestVerifyUsages(t *testing.T) {
+	cert, key := newCAKeyPair(t)
+	spec := api.CfsslIssuerSpec{
+		Mode:         api.LocalMode,
+		Local:        &api.LocalSigner{Config: localConfig},
+		Profile:      "server",
+		Verification: api.StrictVerification,
+	}
+
+	pro, err := NewLocal(spec, WithCAKeyPair(cert, key))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		usages  []cmapi.KeyUsage
+		isCA    bool
+		wantErr string
+	}{
+		{
+			name:   "matching",
+			usages: []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageServerAuth},
+		},
+		{
+			name:    "key usage",
+			usages:  []cmapi.KeyUsage{cmapi.UsageCertSign},
+			wantErr: `key usage "cert sign" is missing`,
+		},
+		{
+			name:    "extended key usage",
+			usages:  []cmapi.KeyUsage{cmapi.UsageClientAuth},
+			wantErr: `key usage "client auth" is missing`,
+		},
+		{
+			name:    "ca",
+			isCA:    true,
+			wantErr: "certificate is not a CA",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := pro.Sign(context.Background(), validCSR, WithUsages(test.usages, test.isCA))
+			if test.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var serr *SignError
+			if assert.ErrorAs(t, err, &serr) {
+				assert.Equal(t, VerificationFailure, serr.Class)
+				assert.Equal(t, test.wantErr, serr.Message)
+			}
+		})
+	}
+}
+
+func TestVerifyCertificateMismatches(t *testing.T) {
+	cert, key := newCAKeyPair(t)
+	pro, err := NewLocal(api.CfsslIssuerSpec{Mode: api.LocalMode}, WithCAKeyPair(cert, key))
+	if err != nil {
+		t.Fatalf("failed to create provisioner: %v", err)
+	}
+	res, err := pro.Sign(context.Background(), validCSR)
+	if err != nil {
+		t.Fatalf("failed to sign csr: %v", err)
+	}
+
+	// a request for another key and SANs the certificate lacks
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames:       []string{"app.example.com"},
+		EmailAddresses: []string{"app@example.com"},
+	}, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = verifyCertificate(res, csr, &signOptions{})
+	assert.EqualError(t, err, `public key does not match the CSR, dns name "app.example.com" is missing, `+
+		`email address "app@example.com" is missing`)
+
+	// a certificate of another CA
+	other, _ := newCAKeyPair(t)
+	res.CA = other
+	req, err := pki.DecodeX509CertificateRequestBytes(validCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCertificate(res, req, &signOptions{}); assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "certificate does not chain to the CA")
+	}
+}